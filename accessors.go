@@ -0,0 +1,58 @@
+package Sleep
+
+import (
+	"reflect"
+)
+
+// Accessors is implemented by the code the sleepgen command generates for a schema. Sleep uses it instead of
+// reflection to reach a document's Sleep.Document field and Id, to call its hooks and to resolve populate paths.
+// Schemas without generated code keep working through reflection.
+//
+// See the sleepgen command in cmd/sleepgen
+type Accessors interface {
+	// SleepDocument returns the schema's embedded Sleep.Document
+	SleepDocument() *Document
+	SleepId() interface{}
+	// SleepSetId panics if the Id is not of the type of the schema's Id field
+	SleepSetId(id interface{})
+	// SleepHook calls the named hook if the schema implements it, e.g. "PreSave". Only Validate returns errors.
+	SleepHook(name string) error
+	// SleepRef returns the value of the reference field at a populate path and the name of the model it references.
+	// It returns false for paths it does not know, which are then resolved through reflection.
+	SleepRef(path string) (value interface{}, model string, ok bool)
+}
+
+// documentOf returns the embedded Sleep.Document of a pointer to a schema
+func documentOf(schema interface{}) *Document {
+	if accessors, ok := schema.(Accessors); ok {
+		return accessors.SleepDocument()
+	}
+	if info := infoOf(schema); info != nil {
+		return reflect.ValueOf(schema).Elem().FieldByIndex(info.document).Addr().Interface().(*Document)
+	}
+	return reflect.ValueOf(schema).Elem().FieldByName("Document").Addr().Interface().(*Document)
+}
+
+// callHook calls one of the hooks of a pointer to a schema. Schemas that do not implement the hook
+// get the stand-in of Sleep.Document.
+func callHook(schema interface{}, name string) error {
+	if accessors, ok := schema.(Accessors); ok {
+		return accessors.SleepHook(name)
+	}
+	var method reflect.Value
+	if info := infoOf(schema); info != nil {
+		index, ok := info.hooks[name]
+		if !ok {
+			return nil
+		}
+		method = reflect.ValueOf(schema).Method(index)
+	} else {
+		method = reflect.ValueOf(schema).MethodByName(name)
+	}
+	results := method.Call([]reflect.Value{})
+	if len(results) == 0 {
+		return nil
+	}
+	err, _ := results[0].Interface().(error)
+	return err
+}
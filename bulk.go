@@ -0,0 +1,388 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"sort"
+)
+
+// DefaultBulkBatchSize is the number of operations a Bulk sends to the server at a time unless changed with Bulk.BatchSize
+var DefaultBulkBatchSize = 1000
+
+type bulkKind int
+
+const (
+	bulkInsert bulkKind = iota
+	bulkUpdate
+	bulkUpsert
+	bulkRemove
+)
+
+// op returns the Op plugins see for a kind of bulk operation
+func (kind bulkKind) op() Op {
+	switch kind {
+	case bulkInsert:
+		return OpInsert
+	case bulkUpdate:
+		return OpUpdate
+	case bulkUpsert:
+		return OpSave
+	}
+	return OpRemove
+}
+
+type bulkOp struct {
+	kind bulkKind
+	doc  interface{}
+	id   interface{}
+}
+
+// Bulk collects write operations on the documents of a model and sends them to the server in batches.
+// Create one using Model.Bulk.
+//
+// The PreSave and Validate hooks of inserted, updated and upserted documents and the PreRemove hook of
+// removed documents are called when the bulk is run, and sequence fields are assigned as with Document.Save.
+// PostSave and PostRemove are called for every operation that succeeded.
+//
+// Removed documents are subject to the `ondelete` rules of the references to them, as with Document.Remove.
+// Removing a document held by a restrict rule fails with ErrReferenced before anything is written. The references
+// to removed documents are nullified and cascaded once the batch holding them is written; if that fails the error
+// is reported for the operation.
+type Bulk struct {
+	model     *Model
+	ops       []bulkOp
+	ordered   bool
+	batchSize int
+}
+
+// BulkResult describes the outcome of running a Bulk
+type BulkResult struct {
+	// number of successful operations of each kind
+	Inserted int
+	Updated  int
+	Upserted int
+	Removed  int
+	// Skipped is the number of operations that were not attempted because an earlier operation of an ordered bulk failed
+	Skipped int
+	// Errors holds the failed operations, in the order they were added to the bulk
+	Errors []BulkOpError
+}
+
+// BulkOpError describes a single failed operation of a Bulk
+type BulkOpError struct {
+	// Index is the position of the operation in the bulk, counting from 0
+	Index int
+	// Doc is the document the operation was performed on
+	Doc interface{}
+	Err error
+}
+
+// BulkError is returned by Bulk.Run when one or more operations failed. The failed operations
+// are listed in the BulkResult returned alongside it.
+type BulkError struct {
+	Result *BulkResult
+}
+
+func (e *BulkError) Error() string {
+	first := e.Result.Errors[0]
+	if len(e.Result.Errors) == 1 {
+		return fmt.Sprintf("bulk operation %d failed: %v", first.Index, first.Err)
+	}
+	return fmt.Sprintf("%d bulk operations failed, first was operation %d: %v", len(e.Result.Errors), first.Index, first.Err)
+}
+
+// Bulk starts a new bulk write on the model's collection. Operations are ordered by default:
+// the first failure stops the bulk and the remaining operations are skipped.
+//
+// Example:
+//
+//	bulk := User.Bulk().Unordered()
+//	for _, user := range imported {
+//		bulk.Upsert(user)
+//	}
+//	result, err := bulk.Run()
+//	if err != nil {
+//		for _, failure := range result.Errors {
+//			//failure.Doc could not be saved because of failure.Err
+//		}
+//	}
+func (m *Model) Bulk() *Bulk {
+	return &Bulk{model: m, ordered: true, batchSize: DefaultBulkBatchSize}
+}
+
+// Unordered lets the server run the operations in any order and continue past failed operations.
+func (b *Bulk) Unordered() *Bulk {
+	b.ordered = false
+	return b
+}
+
+// BatchSize sets the maximum number of operations sent to the server at a time.
+// A size of 0 sends all of the operations in a single batch.
+func (b *Bulk) BatchSize(n int) *Bulk {
+	b.batchSize = n
+	return b
+}
+
+// Insert queues the documents to be inserted. The arguments must be pointers to registered schemas.
+func (b *Bulk) Insert(docs ...interface{}) *Bulk {
+	return b.add(bulkInsert, docs)
+}
+
+// Update queues the documents to replace the stored documents with the same Id.
+func (b *Bulk) Update(docs ...interface{}) *Bulk {
+	return b.add(bulkUpdate, docs)
+}
+
+// Upsert queues the documents to replace the stored documents with the same Id, or to be inserted
+// if they do not exist yet. This is the bulk equivalent of Document.Save
+func (b *Bulk) Upsert(docs ...interface{}) *Bulk {
+	return b.add(bulkUpsert, docs)
+}
+
+// Remove queues the documents to be removed. Documents of soft deleting models are marked as deleted instead.
+func (b *Bulk) Remove(docs ...interface{}) *Bulk {
+	return b.add(bulkRemove, docs)
+}
+
+// Len returns the number of queued operations
+func (b *Bulk) Len() int {
+	return len(b.ops)
+}
+
+func (b *Bulk) add(kind bulkKind, docs []interface{}) *Bulk {
+	for _, doc := range docs {
+		if reflect.TypeOf(doc).Kind() != reflect.Ptr {
+			panic("Expected a pointer, got a value")
+		}
+		b.ops = append(b.ops, bulkOp{kind: kind, doc: doc, id: docId(doc)})
+	}
+	return b
+}
+
+// Run runs the pre hooks and validation of all queued documents and sends the operations to the server.
+// The bulk is emptied and may be reused afterwards.
+//
+// The returned error is a *BulkError if any of the operations failed, including documents that did not pass validation.
+// The result is returned in all cases.
+//
+// The bulk goes through plugins as a single OpBulk operation, see Operation.Writes.
+func (b *Bulk) Run() (*BulkResult, error) {
+	ops := b.ops
+	b.ops = nil
+	result := &BulkResult{}
+	op := &Operation{Op: OpBulk, Model: b.model, Result: result, Writes: make([]Write, len(ops))}
+	for i, bulkOp := range ops {
+		op.Writes[i] = Write{Op: bulkOp.kind.op(), Doc: bulkOp.doc}
+	}
+	err := b.model.z.run(op, func() error {
+		return b.run(ops, result)
+	})
+	return result, err
+}
+
+// run runs the operations of the bulk and records their outcome in result
+func (b *Bulk) run(ops []bulkOp, result *BulkResult) error {
+	//hooks, validation and restrict rules run before anything is written
+	valid := make([]int, 0, len(ops))
+	for i, op := range ops {
+		var err error
+		if op.kind == bulkRemove {
+			err = b.model.checkRestrict(op.id, make(map[refKey]bool))
+			if err == nil {
+				callHook(op.doc, "PreRemove")
+			}
+		} else {
+			callHook(op.doc, "PreSave")
+			b.model.discriminator.set(op.doc)
+			err = validate(op.doc)
+			if err == nil {
+				err = b.model.assignSequences(op.doc)
+			}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, BulkOpError{Index: i, Doc: op.doc, Err: err})
+			if b.ordered {
+				result.Skipped = len(ops) - i - 1
+				break
+			}
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	batchSize := b.batchSize
+	if batchSize < 1 {
+		batchSize = len(valid)
+	}
+	for start := 0; start < len(valid); start += batchSize {
+		end := start + batchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		stopped := b.flush(ops, valid[start:end], result)
+		if stopped && b.ordered {
+			result.Skipped += len(valid) - end
+			break
+		}
+	}
+
+	if len(result.Errors) != 0 {
+		sortBulkErrors(result.Errors)
+		return &BulkError{result}
+	}
+	return nil
+}
+
+// flush sends a single batch of operations to the server and records the outcome in result.
+// It returns true if any of the operations failed.
+func (b *Bulk) flush(ops []bulkOp, batch []int, result *BulkResult) bool {
+	bulk := b.model.C.Bulk()
+	if !b.ordered {
+		bulk.Unordered()
+	}
+	for _, i := range batch {
+		op := ops[i]
+		switch op.kind {
+		case bulkInsert:
+			bulk.Insert(op.doc)
+		case bulkUpdate:
+			bulk.Update(bson.M{"_id": op.id}, op.doc)
+		case bulkUpsert:
+			bulk.Upsert(bson.M{"_id": op.id}, op.doc)
+		case bulkRemove:
+			if b.model.softDelete != nil {
+				bulk.Update(bson.M{"_id": op.id}, b.model.softDelete.mark(op.doc))
+			} else {
+				bulk.Remove(bson.M{"_id": op.id})
+			}
+		}
+	}
+	_, err := bulk.Run()
+	var cases []mgo.BulkErrorCase
+	if bulkErr, ok := err.(*mgo.BulkError); ok {
+		cases = bulkErr.Cases()
+	}
+	failed, firstFailure := batchFailures(cases, err, len(batch))
+
+	for pos, i := range batch {
+		op := ops[i]
+		if opErr, ok := failed[pos]; ok {
+			result.Errors = append(result.Errors, BulkOpError{Index: i, Doc: op.doc, Err: opErr})
+			continue
+		}
+		if b.ordered && pos > firstFailure {
+			result.Skipped++
+			continue
+		}
+		switch op.kind {
+		case bulkInsert:
+			result.Inserted++
+		case bulkUpdate:
+			result.Updated++
+		case bulkUpsert:
+			result.Upserted++
+		case bulkRemove:
+			if b.model.softDelete == nil {
+				if err := b.model.applyDeleteRules(op.id); err != nil {
+					result.Errors = append(result.Errors, BulkOpError{Index: i, Doc: op.doc, Err: err})
+					continue
+				}
+			}
+			result.Removed++
+			callHook(op.doc, "PostRemove")
+			continue
+		}
+		setSaved(op.doc)
+		callHook(op.doc, "PostSave")
+	}
+	return len(failed) != 0
+}
+
+// batchFailures works out which operations of a batch failed from the error returned by the server.
+// It returns the failed operations by their position in the batch, and the position of the first one.
+// If the server did not say which operation failed all of them are reported as failed.
+func batchFailures(cases []mgo.BulkErrorCase, err error, size int) (map[int]error, int) {
+	failed := make(map[int]error)
+	firstFailure := size
+	if err == nil {
+		return failed, firstFailure
+	}
+	for _, c := range cases {
+		if c.Index < 0 || c.Index >= size {
+			failed = nil
+			break
+		}
+		failed[c.Index] = c.Err
+		if c.Index < firstFailure {
+			firstFailure = c.Index
+		}
+	}
+	if len(failed) == 0 {
+		//the whole batch failed, or the server did not say which operation failed
+		failed = make(map[int]error, size)
+		for pos := 0; pos < size; pos++ {
+			failed[pos] = err
+		}
+		firstFailure = 0
+	}
+	return failed, firstFailure
+}
+
+// setSaved marks a conditioned document as existing in the database
+func setSaved(doc interface{}) {
+	document := documentOf(doc)
+	document.Found = true
+	document.isNew = false
+}
+
+func sortBulkErrors(failures []BulkOpError) {
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Index < failures[j].Index })
+}
+
+// SaveAll saves all of the documents in a slice of pointers to the model's schema, inserting those that do not exist yet.
+// Values that were not created with CreateDoc are conditioned first, have their defaults applied and are given
+// a new Id if their Id is empty.
+// Each document's PreSave, Validate and PostSave hooks are called, as with Document.Save
+//
+// The documents are written in a single batch. See Bulk.Run for how errors are reported.
+//
+//	users := []*User{{Name: "Joe"}, {Name: "Jane"}}
+//	result, err := User.SaveAll(users)
+func (m *Model) SaveAll(docs interface{}) (*BulkResult, error) {
+	return m.writeAll(docs, bulkUpsert)
+}
+
+// InsertAll is the same as SaveAll, except that the documents are inserted. Documents that already
+// exist in the collection cause an error.
+func (m *Model) InsertAll(docs interface{}) (*BulkResult, error) {
+	return m.writeAll(docs, bulkInsert)
+}
+
+func (m *Model) writeAll(docs interface{}, kind bulkKind) (*BulkResult, error) {
+	sliceVal := reflect.ValueOf(docs)
+	if sliceVal.Kind() != reflect.Slice {
+		panic(fmt.Sprintf("Expected a slice of pointers, got %v", sliceVal.Type()))
+	}
+	n := sliceVal.Len()
+	bulk := m.Bulk().BatchSize(n)
+	for i := 0; i < n; i++ {
+		doc := sliceVal.Index(i).Interface()
+		m.conditionIfNeeded(doc)
+		bulk.add(kind, []interface{}{doc})
+	}
+	return bulk.Run()
+}
+
+// conditionIfNeeded conditions a schema value that was not created using CreateDoc or returned by a query
+func (m *Model) conditionIfNeeded(doc interface{}) {
+	if documentOf(doc).Model == nil {
+		m.z.conditionDoc(doc)
+		documentOf(doc).isNew = true
+		m.applyZeroDefaults(doc)
+	}
+	if reflect.ValueOf(docId(doc)).IsZero() {
+		m.assignId(doc)
+	}
+}
@@ -0,0 +1,165 @@
+package Sleep
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testItem struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Name     string
+}
+
+func (i *testItem) Validate() error {
+	if i.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func TestBatchFailures(t *testing.T) {
+	errDup := errors.New("duplicate key")
+	errBatch := errors.New("connection reset")
+	tests := []struct {
+		name   string
+		cases  []mgo.BulkErrorCase
+		err    error
+		failed map[int]error
+		first  int
+	}{
+		{"no error", nil, nil, map[int]error{}, 3},
+		{"indexed cases", []mgo.BulkErrorCase{{Index: 2, Err: errDup}, {Index: 1, Err: errDup}}, errDup,
+			map[int]error{1: errDup, 2: errDup}, 1},
+		{"unknown index", []mgo.BulkErrorCase{{Index: -1, Err: errDup}}, errDup,
+			map[int]error{0: errDup, 1: errDup, 2: errDup}, 0},
+		{"index out of range", []mgo.BulkErrorCase{{Index: 3, Err: errDup}}, errDup,
+			map[int]error{0: errDup, 1: errDup, 2: errDup}, 0},
+		{"not a bulk error", nil, errBatch,
+			map[int]error{0: errBatch, 1: errBatch, 2: errBatch}, 0},
+	}
+	for _, test := range tests {
+		failed, first := batchFailures(test.cases, test.err, 3)
+		if !reflect.DeepEqual(failed, test.failed) || first != test.first {
+			t.Errorf("%s: got %v and first %d, want %v and first %d", test.name, failed, first, test.failed, test.first)
+		}
+	}
+}
+
+func TestSortBulkErrors(t *testing.T) {
+	failures := []BulkOpError{{Index: 4}, {Index: 0}, {Index: 2}}
+	sortBulkErrors(failures)
+	for i, want := range []int{0, 2, 4} {
+		if failures[i].Index != want {
+			t.Fatalf("got %+v", failures)
+		}
+	}
+}
+
+func TestBulkValidationOrdered(t *testing.T) {
+	z := offlineSleep()
+	Items := z.Register(testItem{}, "items")
+	items := []*testItem{{}, {Name: "b"}, {Name: "c"}}
+	bulk := Items.Bulk()
+	for _, item := range items {
+		Items.CreateDoc(item)
+		bulk.Insert(item)
+	}
+	result, err := bulk.Run()
+	if _, ok := err.(*BulkError); !ok {
+		t.Fatalf("got error %v, want a *BulkError", err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Index != 0 || result.Errors[0].Doc != items[0] {
+		t.Fatalf("got errors %+v", result.Errors)
+	}
+	if result.Skipped != 2 {
+		t.Errorf("got %d skipped operations, want 2", result.Skipped)
+	}
+	if bulk.Len() != 0 {
+		t.Error("bulk was not emptied")
+	}
+}
+
+func TestBulkValidationUnordered(t *testing.T) {
+	z := offlineSleep()
+	Items := z.Register(testItem{}, "items")
+	items := []*testItem{{}, {}, {}}
+	bulk := Items.Bulk().Unordered()
+	for _, item := range items {
+		Items.CreateDoc(item)
+		bulk.Upsert(item)
+	}
+	result, err := bulk.Run()
+	if err == nil {
+		t.Fatal("invalid documents were accepted")
+	}
+	for i, failure := range result.Errors {
+		if failure.Index != i || failure.Doc != items[i] {
+			t.Errorf("error %d is for operation %d", i, failure.Index)
+		}
+	}
+	if len(result.Errors) != 3 || result.Skipped != 0 {
+		t.Errorf("got %d errors and %d skipped, want 3 and 0", len(result.Errors), result.Skipped)
+	}
+}
+
+func TestBulkErrorIndexes(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Items := z.Register(testItem{}, "items")
+
+	existing := &testItem{Name: "existing"}
+	Items.CreateDoc(existing)
+	if err := existing.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	//operations 1 and 4 insert a document that already exists. Batches of 2 make sure positions
+	//reported by the server for later batches are mapped back to the whole bulk
+	docs := make([]*testItem, 6)
+	bulk := Items.Bulk().Unordered().BatchSize(2)
+	for i := range docs {
+		docs[i] = &testItem{Name: "item"}
+		Items.CreateDoc(docs[i])
+		if i == 1 || i == 4 {
+			docs[i].Id = existing.Id
+		}
+		bulk.Insert(docs[i])
+	}
+	result, err := bulk.Run()
+	if _, ok := err.(*BulkError); !ok {
+		t.Fatalf("got error %v, want a *BulkError", err)
+	}
+	if len(result.Errors) != 2 || result.Errors[0].Index != 1 || result.Errors[1].Index != 4 {
+		t.Fatalf("got errors %+v, want operations 1 and 4", result.Errors)
+	}
+	if result.Errors[1].Doc != docs[4] {
+		t.Error("error is reported for the wrong document")
+	}
+	if result.Inserted != 4 || result.Skipped != 0 {
+		t.Errorf("got %d inserted and %d skipped, want 4 and 0", result.Inserted, result.Skipped)
+	}
+
+	//an ordered bulk stops at the first failure
+	docs = docs[:0]
+	bulk = Items.Bulk().BatchSize(2)
+	for i := 0; i < 5; i++ {
+		doc := &testItem{Name: "ordered"}
+		Items.CreateDoc(doc)
+		if i == 2 {
+			doc.Id = existing.Id
+		}
+		docs = append(docs, doc)
+		bulk.Insert(doc)
+	}
+	result, _ = bulk.Run()
+	if len(result.Errors) != 1 || result.Errors[0].Index != 2 {
+		t.Fatalf("got errors %+v, want operation 2", result.Errors)
+	}
+	if result.Inserted != 2 || result.Skipped != 2 {
+		t.Errorf("got %d inserted and %d skipped, want 2 and 2", result.Inserted, result.Skipped)
+	}
+}
@@ -0,0 +1,163 @@
+package Sleep
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"time"
+)
+
+// ErrReferenced is returned by Document.Remove when the document is referenced by a field tagged with
+// `ondelete:"restrict"`. Nothing is removed when it is returned.
+var ErrReferenced = errors.New("Sleep: document is referenced by other documents")
+
+const (
+	onDeleteCascade  = "cascade"
+	onDeleteNullify  = "nullify"
+	onDeleteRestrict = "restrict"
+)
+
+// reference is a field of a schema that holds the Id(s) of documents of another model
+type reference struct {
+	// field is the Go path of the field, as used with Populate
+	field string
+	// path is the bson path of the field
+	path string
+	// model is the name of the referenced model
+	model   string
+	isSlice bool
+	// index is the index sequence of the field, for reflect.Value.FieldByIndex
+	index []int
+	// onDelete is what happens to this field when the referenced document is removed. Empty means nothing.
+	onDelete string
+}
+
+type refKey struct {
+	model string
+	// id is the idKey of the document's Id
+	id string
+}
+
+// newReferences collects the fields of a schema tagged with the model tag, including fields of embedded structs.
+func newReferences(typ reflect.Type, modelTag string) []reference {
+	return appendReferences(nil, typ, modelTag, "", "", nil)
+}
+
+func appendReferences(refs []reference, typ reflect.Type, modelTag, fieldPrefix, pathPrefix string, indexPrefix []int) []reference {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Type == reflect.TypeOf(Document{}) {
+			continue
+		}
+		name := fieldPrefix + field.Name
+		path := pathPrefix + bsonName(field)
+		index := append(append([]int{}, indexPrefix...), i)
+
+		model := field.Tag.Get(modelTag)
+		onDelete := field.Tag.Get("ondelete")
+		if model == "" {
+			if onDelete != "" {
+				panic("Field `" + typ.Name() + "." + field.Name + "` has an `ondelete` tag but no `" + modelTag + "` tag")
+			}
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+				refs = appendReferences(refs, field.Type, modelTag, name+".", path+".", index)
+			}
+			continue
+		}
+		switch onDelete {
+		case "", onDeleteCascade, onDeleteNullify, onDeleteRestrict:
+		default:
+			panic("Unknown `ondelete` rule `" + onDelete + "` on field `" + typ.Name() + "." + field.Name + "`")
+		}
+		refs = append(refs, reference{field: name, path: path, model: model, index: index,
+			isSlice: isIdSlice(field.Type), onDelete: onDelete})
+	}
+	return refs
+}
+
+// referrers calls fn for every reference of any registered model to this model
+func (m *Model) referrers(fn func(referrer *Model, ref reference) error) error {
+	for _, referrer := range m.z.models {
+		for _, ref := range referrer.refs {
+			if ref.model != m.name || ref.onDelete == "" {
+				continue
+			}
+			err := fn(referrer, ref)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRestrict returns ErrReferenced if the document with the given id, or any document its removal
+// would cascade to, is referenced through a field with the restrict rule.
+func (m *Model) checkRestrict(id interface{}, visited map[refKey]bool) error {
+	key := refKey{m.name, idKey(id)}
+	if visited[key] {
+		return nil
+	}
+	visited[key] = true
+
+	return m.referrers(func(referrer *Model, ref reference) error {
+		filter := referrer.Find(bson.M{ref.path: id}).filter()
+		switch ref.onDelete {
+		case onDeleteRestrict:
+			n, err := referrer.C.Find(filter).Limit(1).Count()
+			if err != nil {
+				return err
+			}
+			if n != 0 {
+				return ErrReferenced
+			}
+		case onDeleteCascade:
+			iter := referrer.C.Find(filter).Select(bson.M{"_id": 1}).Iter()
+			child := bson.D{}
+			for iter.Next(&child) {
+				err := referrer.checkRestrict(lookup(child, "_id"), visited)
+				if err != nil {
+					iter.Close()
+					return err
+				}
+			}
+			return iter.Close()
+		}
+		return nil
+	})
+}
+
+// applyDeleteRules nullifies and cascades the references to a document that has just been removed
+func (m *Model) applyDeleteRules(id interface{}) error {
+	return m.referrers(func(referrer *Model, ref reference) error {
+		filter := bson.M{ref.path: id}
+		switch ref.onDelete {
+		case onDeleteNullify:
+			var change bson.M
+			if ref.isSlice {
+				change = bson.M{"$pull": bson.M{ref.path: id}}
+			} else {
+				change = bson.M{"$unset": bson.M{ref.path: 1}}
+			}
+			op := &Operation{Op: OpUpdate, Model: referrer, Selector: filter, Change: change}
+			return referrer.z.run(op, func() error {
+				_, err := referrer.C.UpdateAll(op.Selector, op.Change)
+				return err
+			})
+		case onDeleteCascade:
+			schemaType := reflect.TypeOf(referrer.z.documents[referrer.name].schemaStruct)
+			docs := reflect.New(reflect.SliceOf(reflect.PtrTo(schemaType)))
+			err := referrer.Find(filter).Exec(docs.Interface())
+			if err != nil {
+				return err
+			}
+			for i := 0; i < docs.Elem().Len(); i++ {
+				result := docs.Elem().Index(i).MethodByName("Remove").Call([]reflect.Value{})
+				if err, _ := result[0].Interface().(error); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,181 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	Document  `bson:"-"`
+	Id        bson.ObjectId `bson:"_id"`
+	Name      string
+	DeletedAt *time.Time `softdelete:"true"`
+	preRemove int
+}
+
+func (u *testUser) PreRemove() {
+	u.preRemove++
+}
+
+type testPost struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId   `bson:"_id"`
+	Author   bson.ObjectId   `model:"testUser" ondelete:"cascade"`
+	Likes    []bson.ObjectId `model:"testUser" ondelete:"nullify"`
+	Review   struct {
+		Reviewer bson.ObjectId `bson:",omitempty" model:"testUser" ondelete:"restrict"`
+	}
+}
+
+func TestNewReferences(t *testing.T) {
+	refs := newReferences(reflect.TypeOf(testPost{}), "model")
+	want := []reference{
+		{field: "Author", path: "author", model: "testUser", index: []int{2}, onDelete: onDeleteCascade},
+		{field: "Likes", path: "likes", model: "testUser", index: []int{3}, isSlice: true, onDelete: onDeleteNullify},
+		{field: "Review.Reviewer", path: "review.reviewer", model: "testUser", index: []int{4, 0}, onDelete: onDeleteRestrict},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("got %+v, want %+v", refs, want)
+	}
+
+	type unknownRule struct {
+		Owner bson.ObjectId `model:"testUser" ondelete:"explode"`
+	}
+	type ruleWithoutModel struct {
+		Owner bson.ObjectId `ondelete:"cascade"`
+	}
+	for _, schema := range []interface{}{unknownRule{}, ruleWithoutModel{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T did not panic", schema)
+				}
+			}()
+			newReferences(reflect.TypeOf(schema), "model")
+		}()
+	}
+}
+
+func TestDeleteRules(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Users := z.Register(testUser{}, "users")
+	Posts := z.Register(testPost{}, "posts")
+
+	author, fan, reviewer := &testUser{Name: "author"}, &testUser{Name: "fan"}, &testUser{Name: "reviewer"}
+	for _, user := range []*testUser{author, fan, reviewer} {
+		Users.CreateDoc(user)
+		if err := user.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	post := &testPost{Author: author.Id, Likes: []bson.ObjectId{fan.Id, author.Id}}
+	post.Review.Reviewer = reviewer.Id
+	Posts.CreateDoc(post)
+	if err := post.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reload := func() *testPost {
+		stored := &testPost{}
+		if err := Posts.FindId(post.Id).Exec(stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+
+	//restrict is checked before anything happens, hooks included
+	if err := reviewer.Remove(); err != ErrReferenced {
+		t.Fatalf("got %v, want ErrReferenced", err)
+	}
+	if reviewer.preRemove != 0 {
+		t.Fatal("PreRemove was called for a document held by a restrict rule")
+	}
+	//the author's post cascades to the reviewer's restrict rule
+	if err := Users.ForceRemoveId(author.Id); err != ErrReferenced {
+		t.Fatalf("got %v, want ErrReferenced", err)
+	}
+
+	//soft deleting leaves the references alone
+	if err := fan.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reload().Likes) != 2 {
+		t.Fatal("soft deleting nullified the reference")
+	}
+	if err := fan.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	//removing for good applies them
+	if err := Users.ForceRemoveId(fan.Id); err != nil {
+		t.Fatal(err)
+	}
+	if likes := reload().Likes; len(likes) != 1 || likes[0] != author.Id {
+		t.Fatalf("got likes %v, want only the author", likes)
+	}
+
+	if err := Posts.UpdateId(post.Id, bson.M{"$unset": bson.M{"review.reviewer": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Users.ForceRemoveId(author.Id); err != nil {
+		t.Fatal(err)
+	}
+	if reload().Found {
+		t.Fatal("the post was not removed with its author")
+	}
+}
+
+type testTag struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Name     string
+}
+
+type testTagged struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId   `bson:"_id"`
+	Tags     []bson.ObjectId `model:"testTag" ondelete:"nullify"`
+	Pinned   bson.ObjectId   `bson:",omitempty" model:"testTag" ondelete:"restrict"`
+}
+
+func TestBulkRemoveDeleteRules(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Tags := z.Register(testTag{}, "tags")
+	Tagged := z.Register(testTagged{}, "tagged")
+
+	pinned, other := &testTag{Name: "pinned"}, &testTag{Name: "other"}
+	_, err := Tags.SaveAll([]*testTag{pinned, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &testTagged{Tags: []bson.ObjectId{pinned.Id, other.Id}, Pinned: pinned.Id}
+	Tagged.CreateDoc(doc)
+	if err := doc.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Tags.Bulk().Unordered().Remove(pinned, other).Run()
+	if _, ok := err.(*BulkError); !ok {
+		t.Fatalf("got error %v, want a *BulkError", err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Index != 0 || result.Errors[0].Err != ErrReferenced {
+		t.Fatalf("got errors %+v", result.Errors)
+	}
+	if result.Removed != 1 {
+		t.Fatalf("removed %d documents, want 1", result.Removed)
+	}
+
+	if n, _ := Tags.C.FindId(pinned.Id).Count(); n != 1 {
+		t.Error("a document held by a restrict rule was removed")
+	}
+	stored := &testTagged{}
+	if err := Tagged.FindId(doc.Id).Exec(stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Tags) != 1 || stored.Tags[0] != pinned.Id {
+		t.Errorf("got tags %v, want the removed tag to be pulled", stored.Tags)
+	}
+}
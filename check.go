@@ -0,0 +1,171 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// checkBatchSize is the number of referencing documents whose references are looked up at a time
+const checkBatchSize = 1000
+
+// CheckOptions configures Sleep.CheckReferences
+type CheckOptions struct {
+	// Samples is the maximum number of referencing document ids reported per field. Defaults to 10
+	Samples int
+	// Fix removes the dangling references: single references are unset and slices of references have them pulled.
+	// Each fixed document is updated as an OpUpdate operation that goes through plugins.
+	Fix bool
+}
+
+// DanglingReferences reports the references of one field to documents that do not exist
+type DanglingReferences struct {
+	// Model is the name of the model holding the references and Field the Go path of the field
+	Model string
+	Field string
+	// Target is the name of the referenced model
+	Target string
+	// Documents is the number of documents with at least one dangling reference in this field
+	Documents int
+	// References is the total number of dangling references in this field
+	References int
+	// Samples holds the Ids of some of the documents with dangling references
+	Samples []interface{}
+	// Fixed is the number of documents that were fixed, if CheckOptions.Fix was set
+	Fixed int
+}
+
+// CheckReferences walks the fields of every registered model that are tagged with the model tag and reports
+// references to documents that do not exist. Only fields with dangling references are reported.
+//
+//	report, err := sleep.CheckReferences(Sleep.CheckOptions{Samples: 5})
+//	for _, dangling := range report {
+//		fmt.Printf("%s.%s: %d documents reference missing %s documents\n",
+//			dangling.Model, dangling.Field, dangling.Documents, dangling.Target)
+//	}
+//
+// Reading every referencing document can take a long time on large collections.
+func (z *Sleep) CheckReferences(opts CheckOptions) ([]DanglingReferences, error) {
+	if opts.Samples == 0 {
+		opts.Samples = 10
+	}
+	report := []DanglingReferences{}
+	for _, model := range z.models {
+		for _, ref := range model.refs {
+			target, ok := z.models[ref.model]
+			if !ok {
+				panic("Unable to find `" + ref.model + "` schema referenced by `" + model.name + "." + ref.field + "`. Was it registered?")
+			}
+			dangling := DanglingReferences{Model: model.name, Field: ref.field, Target: ref.model}
+			err := model.checkReference(ref, target, opts, &dangling)
+			if err != nil {
+				return report, err
+			}
+			if dangling.Documents != 0 {
+				report = append(report, dangling)
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkReference fills in the report for a single reference field
+func (m *Model) checkReference(ref reference, target *Model, opts CheckOptions, report *DanglingReferences) error {
+	iter := m.C.Find(bson.M{ref.path: bson.M{"$exists": true}}).Select(bson.M{ref.path: 1}).Iter()
+	batch := []bson.D{}
+	doc := bson.D{}
+	for iter.Next(&doc) {
+		batch = append(batch, doc)
+		doc = bson.D{}
+		if len(batch) == checkBatchSize {
+			err := m.checkBatch(batch, ref, target, opts, report)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	err := iter.Close()
+	if err != nil {
+		return err
+	}
+	return m.checkBatch(batch, ref, target, opts, report)
+}
+
+func (m *Model) checkBatch(batch []bson.D, ref reference, target *Model, opts CheckOptions, report *DanglingReferences) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	refIds := make([][]interface{}, len(batch))
+	all := []interface{}{}
+	for i, doc := range batch {
+		refIds[i] = referenceIds(doc, ref.path)
+		all = append(all, refIds[i]...)
+	}
+
+	//Ids are compared by their idKey, as composite and binary Ids can not be map keys
+	existing := make(map[string]bool)
+	iter := target.C.Find(bson.M{"_id": bson.M{"$in": all}}).Select(bson.M{"_id": 1}).Iter()
+	found := bson.D{}
+	for iter.Next(&found) {
+		existing[idKey(lookup(found, "_id"))] = true
+	}
+	err := iter.Close()
+	if err != nil {
+		return err
+	}
+
+	for i, doc := range batch {
+		missing := []interface{}{}
+		for _, id := range refIds[i] {
+			if !existing[idKey(id)] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		report.Documents++
+		report.References += len(missing)
+		if len(report.Samples) < opts.Samples {
+			report.Samples = append(report.Samples, lookup(doc, "_id"))
+		}
+		if !opts.Fix {
+			continue
+		}
+		var change bson.M
+		if ref.isSlice {
+			change = bson.M{"$pull": bson.M{ref.path: bson.M{"$in": missing}}}
+		} else {
+			change = bson.M{"$unset": bson.M{ref.path: 1}}
+		}
+		op := &Operation{Op: OpUpdate, Model: m, Id: lookup(doc, "_id"), Change: change}
+		err = m.z.run(op, func() error {
+			return m.C.UpdateId(op.Id, op.Change)
+		})
+		if err != nil {
+			return err
+		}
+		report.Fixed++
+	}
+	return nil
+}
+
+// referenceIds reads the referenced Ids stored under a dotted path of a document
+func referenceIds(doc bson.D, path string) []interface{} {
+	var val interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		d, ok := val.(bson.D)
+		if !ok {
+			return nil
+		}
+		val = lookup(d, part)
+	}
+	switch ids := val.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return ids
+	}
+	return []interface{}{val}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mansoor-s/Sleep"
+)
+
+// Check implements the `check` command, the command line front end of Sleep.CheckReferences.
+// It exits with 1 if dangling references were found and not fixed.
+//
+//	usage: check [--fix] [--samples n]
+func Check(z *Sleep.Sleep, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	fix := flags.Bool("fix", false, "unset single references and pull slice references to missing documents")
+	samples := flags.Int("samples", 10, "number of document ids to show per field")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	report, err := z.CheckReferences(Sleep.CheckOptions{Samples: *samples, Fix: *fix})
+	if err != nil {
+		fmt.Fprintln(Stderr, "check failed:", err)
+		return 1
+	}
+	if len(report) == 0 {
+		fmt.Fprintln(Stdout, "no dangling references found")
+		return 0
+	}
+
+	for _, dangling := range report {
+		fmt.Fprintf(Stdout, "%s.%s -> %s: %d dangling references in %d documents\n",
+			dangling.Model, dangling.Field, dangling.Target, dangling.References, dangling.Documents)
+		for _, id := range dangling.Samples {
+			fmt.Fprintf(Stdout, "    %v\n", id)
+		}
+		if *fix {
+			fmt.Fprintf(Stdout, "    fixed %d documents\n", dangling.Fixed)
+		}
+	}
+	if *fix {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,74 @@
+// Package cli implements Sleep's maintenance commands.
+//
+// The commands work on the models registered with a Sleep value, so they can not be shipped as a
+// ready-made binary. Instead, build a small command in your project that registers your models and hands over to Main:
+//
+//	package main
+//
+//	import (
+//		"github.com/mansoor-s/Sleep"
+//		"github.com/mansoor-s/Sleep/cli"
+//		"gopkg.in/mgo.v2"
+//		"os"
+//		"myapp/models"
+//		_ "myapp/migrations"
+//	)
+//
+//	func main() {
+//		session, err := mgo.Dial(os.Getenv("MONGO_URL"))
+//		if err != nil {
+//			panic(err)
+//		}
+//		sleep := Sleep.New(session, "MY_DB_NAME")
+//		models.Register(sleep)
+//		os.Exit(cli.Main(sleep, os.Args[1:]))
+//	}
+//
+// Then run it as, for example:
+//
+//	sleep check --fix
+//	sleep migrate up
+//	sleep schema-diff --samples 500
+package cli
+
+import (
+	"fmt"
+	"github.com/mansoor-s/Sleep"
+	"io"
+	"os"
+)
+
+// Stdout and Stderr are where the commands write their output
+var (
+	Stdout io.Writer = os.Stdout
+	Stderr io.Writer = os.Stderr
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(z *Sleep.Sleep, args []string) int
+}
+
+var commands = []command{
+	{"check", "report (and optionally fix) references to documents that do not exist", Check},
+	{"migrate", "apply, revert and list schema migrations", Migrate},
+	{"schema-diff", "compare the registered schemas with the stored documents and indexes", SchemaDiff},
+}
+
+// Main runs the command named by the first argument and returns the exit code
+func Main(z *Sleep.Sleep, args []string) int {
+	if len(args) != 0 {
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				return cmd.run(z, args[1:])
+			}
+		}
+		fmt.Fprintf(Stderr, "unknown command %q\n\n", args[0])
+	}
+	fmt.Fprintln(Stderr, "usage: <command> [arguments]\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(Stderr, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+	return 2
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mansoor-s/Sleep"
+	"strings"
+)
+
+// SchemaDiff implements the `schema-diff` command, the command line front end of Sleep.Diff.
+// It exits with 1 if differences were found.
+//
+//	usage: schema-diff [--samples n]
+func SchemaDiff(z *Sleep.Sleep, args []string) int {
+	flags := flag.NewFlagSet("schema-diff", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	samples := flags.Int("samples", 100, "number of documents to sample from each collection")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+
+	report, err := z.Diff(Sleep.DiffOptions{Samples: *samples})
+	if err != nil {
+		fmt.Fprintln(Stderr, "schema-diff failed:", err)
+		return 1
+	}
+	if len(report) == 0 {
+		fmt.Fprintln(Stdout, "no differences found")
+		return 0
+	}
+
+	for _, drift := range report {
+		fmt.Fprintf(Stdout, "%s (%s, %d documents sampled)\n", drift.Model, drift.Collection, drift.Documents)
+		for _, field := range drift.ExtraFields {
+			fmt.Fprintf(Stdout, "    extra field %s (%s) in %d documents, e.g. %v\n",
+				field.Path, field.Found, field.Documents, field.Sample)
+		}
+		for _, field := range drift.TypeMismatches {
+			fmt.Fprintf(Stdout, "    field %s is %s instead of %s in %d documents, e.g. %v\n",
+				field.Path, field.Found, strings.Join(field.Expected, " or "), field.Documents, field.Sample)
+		}
+		for _, index := range drift.MissingIndexes {
+			fmt.Fprintf(Stdout, "    missing index %s\n", strings.Join(index.Key, ", "))
+		}
+	}
+	return 1
+}
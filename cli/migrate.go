@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/mansoor-s/Sleep"
+	"github.com/mansoor-s/Sleep/migrations"
+	"strconv"
+)
+
+const migrateUsage = "usage: migrate up|down|status|to <version>"
+
+// Migrate implements the `migrate` command, the command line front end of migrations.Migrator.
+// The migrations must have been registered with migrations.Register before it is called.
+//
+//	migrate up            apply all pending migrations
+//	migrate down          revert the latest applied migration
+//	migrate status        list migrations and whether they are applied
+//	migrate to <version>  apply or revert migrations until the given version is the latest applied
+func Migrate(z *Sleep.Sleep, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(Stderr, migrateUsage)
+		return 2
+	}
+	migrator := migrations.New(z)
+
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up()
+		printMigrations("applied", done, err)
+		return reportMigrateErr(err)
+	case "down":
+		done, err := migrator.Down()
+		if err != nil {
+			return reportMigrateErr(err)
+		}
+		if done == nil {
+			fmt.Fprintln(Stdout, "no migrations to revert")
+			return 0
+		}
+		printMigrations("reverted", []migrations.Migration{*done}, nil)
+		return 0
+	case "to":
+		if len(args) != 2 {
+			fmt.Fprintln(Stderr, migrateUsage)
+			return 2
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintf(Stderr, "invalid version %q\n", args[1])
+			return 2
+		}
+		done, err := migrator.To(version)
+		printMigrations("ran", done, err)
+		return reportMigrateErr(err)
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return reportMigrateErr(err)
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(Stdout, "%d  %-28s %s\n", s.Version, applied, s.Name)
+		}
+		return 0
+	}
+	fmt.Fprintln(Stderr, migrateUsage)
+	return 2
+}
+
+// printMigrations lists the migrations that were run. When running them failed, only those that completed are listed
+// and the error is left to reportMigrateErr.
+func printMigrations(verb string, done []migrations.Migration, err error) {
+	if len(done) == 0 && err == nil {
+		fmt.Fprintln(Stdout, "nothing to do")
+		return
+	}
+	for _, migration := range done {
+		fmt.Fprintf(Stdout, "%s %d %s\n", verb, migration.Version, migration.Name)
+	}
+}
+
+func reportMigrateErr(err error) int {
+	if err != nil {
+		fmt.Fprintln(Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,542 @@
+// Command sleepgen generates reflection-free accessors for Sleep schemas.
+//
+// It reads the Go files of a package, finds the struct types that embed Sleep.Document and writes code
+// implementing Sleep.Accessors for each of them, which Sleep then uses instead of reflection to reach the
+// document, its Id, its hooks and its populate paths. Along with it come constants holding the stored names
+// of the schema's fields and a typed wrapper of the schema's model.
+//
+// Add it to the package holding your schemas:
+//
+//	//go:generate sleepgen
+//
+// For the schema
+//
+//	type User struct {
+//		Sleep.Document `bson:"-"`
+//		Id      bson.ObjectId `bson:"_id"`
+//		Name    string
+//		Friend  bson.ObjectId `model:"User"`
+//	}
+//
+// the generated code includes
+//
+//	const (
+//		UserFieldId     = "_id"
+//		UserFieldName   = "name"
+//		UserFieldFriend = "friend"
+//	)
+//
+//	type UserModel struct {
+//		*Sleep.Model
+//	}
+//
+//	func NewUserModel(z *Sleep.Sleep) UserModel
+//	func (m UserModel) FindId(id bson.ObjectId) (*User, error)
+//	func (m UserModel) FindOne(query interface{}) (*User, error)
+//	func (m UserModel) FindAll(query interface{}) ([]*User, error)
+//	func (m UserModel) Create() *User
+//
+// Hooks are found through the method set of the schema, so hooks of an embedded struct that itself embeds
+// Sleep.Document are called as well. Run it again whenever a schema changes. Populate paths are generated for reference fields of the schema and
+// of structs declared in the same package; other paths are still resolved through reflection.
+//
+// Usage:
+//
+//	sleepgen [-dir .] [-output sleep_gen.go] [-type User,Post] [-modeltag model]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const sleepPath = "github.com/mansoor-s/Sleep"
+
+// hookNames lists the hooks Sleep calls on documents
+var hookNames = []string{"PreSave", "Validate", "PostSave", "PreRemove", "PostRemove", "OnCreate", "OnResult"}
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the package holding the schemas")
+	output := flag.String("output", "sleep_gen.go", "name of the generated file, relative to -dir")
+	typeNames := flag.String("type", "", "comma separated list of schemas to generate code for. Defaults to all of them")
+	modelTag := flag.String("modeltag", "model", "struct tag naming referenced models, see Sleep.SetModelTag")
+	flag.Parse()
+
+	pkg, err := parsePackage(*dir, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sleepgen:", err)
+		os.Exit(1)
+	}
+	schemas := pkg.schemas(*modelTag)
+	if *typeNames != "" {
+		schemas = only(schemas, strings.Split(*typeNames, ","))
+	}
+	if len(schemas) == 0 {
+		fmt.Fprintln(os.Stderr, "sleepgen: no schemas embedding Sleep.Document found in", *dir)
+		os.Exit(1)
+	}
+
+	src, err := generate(pkg, schemas)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sleepgen:", err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(filepath.Join(*dir, *output), src, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sleepgen:", err)
+		os.Exit(1)
+	}
+}
+
+// pkg holds the parsed files of the package
+type pkg struct {
+	name  string
+	fset  *token.FileSet
+	files []*ast.File
+	//types is the type checked package, used to find the method sets of the schemas
+	types *types.Package
+	//structs are the struct types declared in the package, by name
+	structs map[string]*ast.StructType
+	//imports maps the local names of the imports of the files declaring each struct to their paths
+	imports map[string]map[string]string
+	//sleepName is the local name of the Sleep package in the files declaring each struct
+	sleepName map[string]string
+}
+
+func parsePackage(dir, output string) (*pkg, error) {
+	fset := token.NewFileSet()
+	filter := func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != output
+	}
+	pkgs, err := parser.ParseDir(fset, dir, filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected a single package in %s, found %d", dir, len(pkgs))
+	}
+
+	p := &pkg{fset: fset, structs: make(map[string]*ast.StructType),
+		imports: make(map[string]map[string]string), sleepName: make(map[string]string)}
+	for name, astPkg := range pkgs {
+		p.name = name
+		for _, file := range astPkg.Files {
+			p.files = append(p.files, file)
+		}
+	}
+	//map iteration order is random, keep the output stable
+	sort.Slice(p.files, func(i, j int) bool { return p.files[i].Pos() < p.files[j].Pos() })
+
+	//the package may not type check without the file being generated, or with imports that can not be
+	//resolved; the method sets are still complete for everything that was resolved
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil), Error: func(error) {}}
+	p.types, _ = conf.Check(p.name, fset, p.files, nil)
+
+	for _, file := range p.files {
+		imports := fileImports(file)
+		sleepName := ""
+		for name, path := range imports {
+			if path == sleepPath {
+				sleepName = name
+			}
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				if structType, ok := typeSpec.Type.(*ast.StructType); ok {
+					p.structs[typeSpec.Name.Name] = structType
+					p.imports[typeSpec.Name.Name] = imports
+					p.sleepName[typeSpec.Name.Name] = sleepName
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+func fileImports(file *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
+	return imports
+}
+
+// schema describes what is generated for a struct embedding Sleep.Document
+type schema struct {
+	name      string
+	sleepName string
+	idType    ast.Expr
+	fields    []field
+	refs      []field
+	hooks     []hook
+}
+
+type field struct {
+	//path is the Go path of the field and key its dotted bson path. name is the path without the
+	//structs tagged with `bson:",inline"`, as the field is promoted from them
+	path  string
+	key   string
+	name  string
+	model string
+}
+
+type hook struct {
+	name       string
+	hasResults bool
+}
+
+func (p *pkg) schemas(modelTag string) []*schema {
+	schemas := []*schema{}
+	for name, structType := range p.structs {
+		sleepName := p.sleepName[name]
+		if sleepName == "" || !embedsDocument(structType, sleepName) {
+			if !p.promotesDocument(name) {
+				continue
+			}
+			//Sleep.Document comes from a struct declared in a file that may be the only one importing Sleep
+			if sleepName == "" {
+				sleepName = "Sleep"
+			}
+		}
+		s := &schema{name: name, sleepName: sleepName}
+		for _, f := range structType.Fields.List {
+			for _, ident := range f.Names {
+				if ident.Name == "Id" {
+					s.idType = f.Type
+				}
+			}
+		}
+		if s.idType == nil {
+			fmt.Fprintf(os.Stderr, "sleepgen: skipping %s, it has no Id field\n", name)
+			continue
+		}
+		p.walkFields(s, structType, "", "", "", modelTag, map[string]bool{name: true})
+		s.hooks = p.hooks(name)
+		schemas = append(schemas, s)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].name < schemas[j].name })
+	return schemas
+}
+
+func embedsDocument(structType *ast.StructType, sleepName string) bool {
+	for _, f := range structType.Fields.List {
+		if len(f.Names) != 0 {
+			continue
+		}
+		if sel, ok := f.Type.(*ast.SelectorExpr); ok && sel.Sel.Name == "Document" {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == sleepName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// promotesDocument reports whether the type embeds Sleep.Document through one of its embedded structs
+func (p *pkg) promotesDocument(typeName string) bool {
+	obj := p.types.Scope().Lookup(typeName)
+	if obj == nil {
+		return false
+	}
+	field, _, _ := types.LookupFieldOrMethod(obj.Type(), true, p.types, "Document")
+	v, ok := field.(*types.Var)
+	return ok && v.Anonymous() && isDocument(v.Type())
+}
+
+// walkFields collects the persisted fields and the reference fields of a struct, following structs declared in the package.
+// The fields of structs tagged with `bson:",inline"` are collected in place of the struct, as Sleep does.
+func (p *pkg) walkFields(s *schema, structType *ast.StructType, pathPrefix, keyPrefix, namePrefix, modelTag string, visiting map[string]bool) {
+	for _, f := range structType.Fields.List {
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			value, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(value)
+		}
+		//only plain struct values can be part of a populate path
+		typeIdent, _ := f.Type.(*ast.Ident)
+		var nested *ast.StructType
+		if typeIdent != nil && !visiting[typeIdent.Name] {
+			nested = p.structs[typeIdent.Name]
+		}
+		names := f.Names
+		embedded := len(names) == 0
+		if embedded {
+			//embedded fields are named after their type
+			ident := embeddedName(f.Type)
+			if ident == nil {
+				continue
+			}
+			names = []*ast.Ident{ident}
+		}
+		for _, ident := range names {
+			key := bsonName(ident.Name, tag)
+			if !ident.IsExported() || key == "-" {
+				continue
+			}
+			fld := field{path: pathPrefix + ident.Name, key: keyPrefix + key, name: namePrefix + ident.Name, model: tag.Get(modelTag)}
+			if isInline(tag) {
+				//the fields of inlined structs are stored in the parent document, and those of embedded ones
+				//are promoted to it. Inlined maps hold any other keys.
+				if nested != nil {
+					name := fld.name + "."
+					if embedded {
+						name = namePrefix
+					}
+					visiting[typeIdent.Name] = true
+					p.walkFields(s, nested, fld.path+".", keyPrefix, name, modelTag, visiting)
+					delete(visiting, typeIdent.Name)
+				}
+				continue
+			}
+			s.fields = append(s.fields, fld)
+			if fld.model != "" {
+				s.refs = append(s.refs, fld)
+			}
+			if nested != nil {
+				visiting[typeIdent.Name] = true
+				p.walkFields(s, nested, fld.path+".", fld.key+".", fld.name+".", modelTag, visiting)
+				delete(visiting, typeIdent.Name)
+			}
+		}
+	}
+}
+
+// embeddedName returns the name of an embedded field, which is the name of its type
+func embeddedName(typ ast.Expr) *ast.Ident {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.Ident:
+		return t
+	case *ast.SelectorExpr:
+		return t.Sel
+	}
+	return nil
+}
+
+// isInline reports whether the tag has the inline flag, `bson:",inline"`
+func isInline(tag reflect.StructTag) bool {
+	for _, flag := range strings.Split(tag.Get("bson"), ",")[1:] {
+		if flag == "inline" {
+			return true
+		}
+	}
+	return false
+}
+
+// bsonName returns the key a field is stored under, the way Sleep and mgo do
+func bsonName(name string, tag reflect.StructTag) string {
+	value := tag.Get("bson")
+	if value == "" && !strings.Contains(string(tag), ":") {
+		value = string(tag)
+	}
+	key := strings.Split(value, ",")[0]
+	if key == "" {
+		key = strings.ToLower(name)
+	}
+	return key
+}
+
+// hooks returns the hooks in the method set of a pointer to the type, including hooks promoted from embedded
+// structs. The stand-ins promoted from Sleep.Document do nothing and are left out.
+func (p *pkg) hooks(typeName string) []hook {
+	hooks := []hook{}
+	obj := p.types.Scope().Lookup(typeName)
+	if obj == nil {
+		return hooks
+	}
+	methods := types.NewMethodSet(types.NewPointer(obj.Type()))
+	for _, name := range hookNames {
+		sel := methods.Lookup(p.types, name)
+		if sel == nil {
+			continue
+		}
+		sig := sel.Obj().Type().(*types.Signature)
+		if isDocument(sig.Recv().Type()) {
+			continue
+		}
+		hooks = append(hooks, hook{name: name, hasResults: sig.Results().Len() != 0})
+	}
+	return hooks
+}
+
+// isDocument reports whether the type is Sleep.Document or a pointer to it
+func isDocument(typ types.Type) bool {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	named, ok := typ.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == sleepPath && named.Obj().Name() == "Document"
+}
+
+func only(schemas []*schema, names []string) []*schema {
+	selected := []*schema{}
+	for _, s := range schemas {
+		for _, name := range names {
+			if strings.TrimSpace(name) == s.name {
+				selected = append(selected, s)
+			}
+		}
+	}
+	return selected
+}
+
+func generate(p *pkg, schemas []*schema) ([]byte, error) {
+	//the Id types may refer to other packages, which the generated file has to import as well
+	imports := map[string]string{schemas[0].sleepName: sleepPath}
+	for _, s := range schemas {
+		fileImports := p.imports[s.name]
+		ast.Inspect(s.idType, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					imports[x.Name] = fileImports[x.Name]
+				}
+			}
+			return true
+		})
+		imports[s.sleepName] = sleepPath
+	}
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by sleepgen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", p.name)
+	for _, name := range names {
+		path := imports[name]
+		if path[strings.LastIndex(path, "/")+1:] == name {
+			fmt.Fprintf(buf, "\t%q\n", path)
+		} else {
+			fmt.Fprintf(buf, "\t%s %q\n", name, path)
+		}
+	}
+	fmt.Fprintln(buf, ")")
+	for _, s := range schemas {
+		writeSchema(buf, s)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+func writeSchema(buf *bytes.Buffer, s *schema) {
+	sleep := s.sleepName
+	idType := types.ExprString(s.idType)
+
+	fmt.Fprintf(buf, "\n// Keys the fields of %s are stored under\nconst (\n", s.name)
+	for _, f := range s.fields {
+		fmt.Fprintf(buf, "\t%sField%s = %q\n", s.name, strings.Replace(f.name, ".", "", -1), f.key)
+	}
+	fmt.Fprintln(buf, ")")
+
+	fmt.Fprintf(buf, `
+// SleepDocument implements %[2]s.Accessors
+func (doc *%[1]s) SleepDocument() *%[2]s.Document {
+	return &doc.Document
+}
+
+// SleepId implements %[2]s.Accessors
+func (doc *%[1]s) SleepId() interface{} {
+	return doc.Id
+}
+
+// SleepSetId implements %[2]s.Accessors
+func (doc *%[1]s) SleepSetId(id interface{}) {
+	doc.Id = id.(%[3]s)
+}
+
+// SleepHook implements %[2]s.Accessors
+func (doc *%[1]s) SleepHook(name string) error {
+`, s.name, sleep, idType)
+	if len(s.hooks) != 0 {
+		fmt.Fprintln(buf, "\tswitch name {")
+		for _, h := range s.hooks {
+			if h.hasResults {
+				fmt.Fprintf(buf, "\tcase %q:\n\t\treturn doc.%s()\n", h.name, h.name)
+			} else {
+				fmt.Fprintf(buf, "\tcase %q:\n\t\tdoc.%s()\n", h.name, h.name)
+			}
+		}
+		fmt.Fprintln(buf, "\t}")
+	}
+	fmt.Fprintf(buf, "\treturn nil\n}\n\n// SleepRef implements %s.Accessors\nfunc (doc *%s) SleepRef(path string) (interface{}, string, bool) {\n",
+		sleep, s.name)
+	if len(s.refs) != 0 {
+		fmt.Fprintln(buf, "\tswitch path {")
+		for _, f := range s.refs {
+			fmt.Fprintf(buf, "\tcase %q:\n\t\treturn doc.%s, %q, true\n", f.path, f.path, f.model)
+		}
+		fmt.Fprintln(buf, "\t}")
+	}
+	fmt.Fprintln(buf, "\treturn nil, \"\", false\n}")
+
+	fmt.Fprintf(buf, `
+// %[1]sModel gives typed access to the model registered for %[1]s
+type %[1]sModel struct {
+	*%[2]s.Model
+}
+
+// New%[1]sModel returns the typed wrapper of the model registered for %[1]s
+func New%[1]sModel(z *%[2]s.Sleep) %[1]sModel {
+	return %[1]sModel{z.Model(%[1]q)}
+}
+
+// FindId returns the %[1]s with the given Id. Use IsValid to check whether it was found.
+func (m %[1]sModel) FindId(id %[3]s) (*%[1]s, error) {
+	doc := &%[1]s{}
+	err := m.Model.FindId(id).Exec(doc)
+	return doc, err
+}
+
+// FindOne returns the first %[1]s matching the query. Use IsValid to check whether it was found.
+func (m %[1]sModel) FindOne(query interface{}) (*%[1]s, error) {
+	doc := &%[1]s{}
+	err := m.Model.Find(query).Exec(doc)
+	return doc, err
+}
+
+// FindAll returns every %[1]s matching the query
+func (m %[1]sModel) FindAll(query interface{}) ([]*%[1]s, error) {
+	docs := []*%[1]s{}
+	err := m.Model.Find(query).Exec(&docs)
+	return docs, err
+}
+
+// Create returns a new %[1]s conditioned by CreateDoc
+func (m %[1]sModel) Create() *%[1]s {
+	doc := &%[1]s{}
+	m.Model.CreateDoc(doc)
+	return doc
+}
+`, s.name, sleep, idType)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestHooks(t *testing.T) {
+	p, err := parsePackage("testdata/hooks", "sleep_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := make(map[string][]hook)
+	for _, s := range p.schemas("model") {
+		hooks[s.name] = s.hooks
+	}
+	want := map[string][]hook{
+		"Post": {
+			{name: "PreSave"},
+			{name: "Validate", hasResults: true},
+			{name: "OnResult"},
+		},
+		"Plain": {},
+	}
+	if !reflect.DeepEqual(hooks, want) {
+		t.Fatalf("got hooks %+v, want %+v", hooks, want)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	p, err := parsePackage("testdata/hooks", "sleep_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(p, p.schemas("model"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const golden = "testdata/hooks/sleep_gen.golden"
+	if *update {
+		if err := ioutil.WriteFile(golden, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Fatalf("the generated code does not match %s, run the tests with -update if the change is intended:\n%s", golden, src)
+	}
+}
@@ -0,0 +1,38 @@
+package hooks
+
+import (
+	"errors"
+
+	"github.com/mansoor-s/Sleep"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Base holds what every post shares, including its hooks
+type Base struct {
+	Sleep.Document `bson:"-"`
+	Author         string
+	Editor         bson.ObjectId `bson:",omitempty" model:"Plain"`
+}
+
+func (b *Base) Validate() error {
+	if b.Author == "" {
+		return errors.New("author is required")
+	}
+	return nil
+}
+
+type Post struct {
+	Base  `bson:",inline"`
+	Id    bson.ObjectId `bson:"_id"`
+	Title string
+}
+
+func (p *Post) PreSave() {}
+
+func (p Post) OnResult() {}
+
+// Plain only has the stand-ins of Sleep.Document
+type Plain struct {
+	Sleep.Document `bson:"-"`
+	Id             bson.ObjectId `bson:"_id"`
+}
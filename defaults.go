@@ -0,0 +1,161 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"time"
+)
+
+// fieldDefault is a default value declared with the `default` tag on a schema field
+type fieldDefault struct {
+	// field is the Go name and name the bson key of the field
+	field string
+	name  string
+	index []int
+	typ   reflect.Type
+	value string
+	// literal holds the parsed value of a default that is a literal rather than "now", "newid" or a function
+	literal interface{}
+}
+
+// newDefaults collects the `default` tags of a schema's fields. Every default is parsed and checked against the
+// type of its field. Will panic if a default can not be used for its field.
+func newDefaults(z *Sleep, typ reflect.Type) []fieldDefault {
+	defaults := []fieldDefault{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		value := field.Tag.Get("default")
+		if value == "" {
+			continue
+		}
+		d := fieldDefault{field: field.Name, name: bsonName(field), index: field.Index, typ: field.Type, value: value}
+		err := d.parse(z)
+		if err != nil {
+			panic("Invalid default value `" + value + "` for field `" + typ.Name() + "." + field.Name + "`: " + err.Error())
+		}
+		defaults = append(defaults, d)
+	}
+	return defaults
+}
+
+// RegisterDefault registers a function that can be named in `default` tags to compute a field's default value.
+// The value returned must be convertible to the type of the fields it is used on.
+// Functions must be registered before the schemas that use them. Register calls the function once to check its value.
+//
+//	sleep.RegisterDefault("trialEnd", func() interface{} {
+//		return time.Now().AddDate(0, 0, 30)
+//	})
+//
+//	type Account struct {
+//		Sleep.Document `bson:"-"`
+//		Id       bson.ObjectId `bson:"_id"`
+//		Plan     string        `default:"free"`
+//		Credits  int           `default:"100"`
+//		Created  time.Time     `default:"now"`
+//		TrialEnd time.Time     `default:"trialEnd"`
+//	}
+func (z *Sleep) RegisterDefault(name string, fn func() interface{}) {
+	z.defaultFuncs[name] = fn
+}
+
+// parse checks that the default can be assigned to its field and keeps the value of literal defaults.
+// The tag value is read as, in order of precedence: "now" for the current time, "newid" for a new ObjectId,
+// the name of a function registered with Sleep.RegisterDefault, or a literal of the field's type.
+func (d *fieldDefault) parse(z *Sleep) error {
+	_, isFunc := z.defaultFuncs[d.value]
+	if d.value == "now" || d.value == "newid" || isFunc {
+		_, err := d.convert(d.compute(z))
+		return err
+	}
+	switch d.typ.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Errorf("fields of type %v can not have a literal default", d.typ)
+	}
+	val, err := convertParam(d.value, d.typ)
+	if err != nil {
+		return err
+	}
+	_, err = d.convert(val)
+	d.literal = val
+	return err
+}
+
+// compute returns the value of a default that is not a literal
+func (d fieldDefault) compute(z *Sleep) interface{} {
+	switch d.value {
+	case "now":
+		return time.Now()
+	case "newid":
+		return bson.NewObjectId()
+	}
+	return z.defaultFuncs[d.value]()
+}
+
+// convert converts a default value to the type of its field
+func (d fieldDefault) convert(val interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("nil can not be used for a field of type %v", d.typ)
+	}
+	typ := d.typ
+	if typ.Kind() == reflect.Ptr && v.Type() != typ {
+		typ = typ.Elem()
+	}
+	if v.Type() == reflect.TypeOf(bson.ObjectId("")) && typ.Kind() == reflect.String && typ != v.Type() {
+		v = reflect.ValueOf(val.(bson.ObjectId).Hex())
+	}
+	//Go converts integers to strings as runes, which is never what a default means
+	isInt := v.Kind() >= reflect.Int && v.Kind() <= reflect.Uintptr
+	if !v.Type().ConvertibleTo(typ) || (isInt && typ.Kind() == reflect.String) {
+		return reflect.Value{}, fmt.Errorf("a %v can not be used for a field of type %v", v.Type(), d.typ)
+	}
+	v = v.Convert(typ)
+	if typ != d.typ {
+		ptr := reflect.New(typ)
+		ptr.Elem().Set(v)
+		return ptr, nil
+	}
+	return v, nil
+}
+
+// valueFor returns the default value for the field. Every call returns a new value.
+func (d fieldDefault) valueFor(z *Sleep) reflect.Value {
+	val := d.literal
+	if val == nil {
+		val = d.compute(z)
+	}
+	v, err := d.convert(val)
+	if err != nil {
+		panic("Invalid default value `" + d.value + "` for field `" + d.field + "`: " + err.Error())
+	}
+	return v
+}
+
+// applyZeroDefaults sets the default value of every zero-valued field of a new document
+func (m *Model) applyZeroDefaults(schema interface{}) {
+	val := reflect.ValueOf(schema).Elem()
+	for _, d := range m.defaults {
+		field := val.FieldByIndex(d.index)
+		if field.IsZero() {
+			field.Set(d.valueFor(m.z))
+		}
+	}
+}
+
+// applyMissingDefaults sets the default value of every field that was missing from a stored document
+func (m *Model) applyMissingDefaults(schema interface{}, doc bson.RawD) {
+	if len(m.defaults) == 0 {
+		return
+	}
+	stored := make(map[string]bool, len(doc))
+	for _, elem := range doc {
+		stored[elem.Name] = true
+	}
+	val := reflect.ValueOf(schema).Elem()
+	for _, d := range m.defaults {
+		if !stored[d.name] {
+			val.FieldByIndex(d.index).Set(d.valueFor(m.z))
+		}
+	}
+}
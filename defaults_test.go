@@ -0,0 +1,86 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAccountDefaults struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Plan     string        `default:"free"`
+	Credits  int           `default:"100"`
+	Limit    *float64      `default:"1.5"`
+	Created  time.Time     `default:"now"`
+	Ref      string        `default:"newid"`
+	TrialEnd time.Time     `default:"trialEnd"`
+}
+
+func TestDefaults(t *testing.T) {
+	z := offlineSleep()
+	trialEnd := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	z.RegisterDefault("trialEnd", func() interface{} { return trialEnd })
+	Accounts := z.Register(testAccountDefaults{}, "accounts")
+
+	a, b := &testAccountDefaults{}, &testAccountDefaults{Plan: "pro"}
+	Accounts.CreateDoc(a)
+	Accounts.CreateDoc(b)
+	if a.Plan != "free" || a.Credits != 100 || a.Limit == nil || *a.Limit != 1.5 || !a.TrialEnd.Equal(trialEnd) {
+		t.Fatalf("defaults were not applied: %+v", a)
+	}
+	if a.Created.IsZero() || !bson.IsObjectIdHex(a.Ref) {
+		t.Fatalf("computed defaults were not applied: %+v", a)
+	}
+	if b.Plan != "pro" {
+		t.Error("a default replaced a value that was set")
+	}
+	if a.Limit == b.Limit || a.Ref == b.Ref {
+		t.Error("documents share their default values")
+	}
+}
+
+func TestInvalidDefaults(t *testing.T) {
+	type badLiteral struct {
+		Id      bson.ObjectId `bson:"_id"`
+		Credits int           `default:"lots"`
+	}
+	type nowOnString struct {
+		Id      bson.ObjectId `bson:"_id"`
+		Created string        `default:"now"`
+	}
+	type scalarOnSlice struct {
+		Id   bson.ObjectId `bson:"_id"`
+		Tags []string      `default:"go"`
+	}
+	type wrongFuncType struct {
+		Id      bson.ObjectId `bson:"_id"`
+		Created time.Time     `default:"number"`
+	}
+	type intFuncOnString struct {
+		Id   bson.ObjectId `bson:"_id"`
+		Name string        `default:"number"`
+	}
+	type nilFunc struct {
+		Id   bson.ObjectId `bson:"_id"`
+		Name string        `default:"nothing"`
+	}
+	for _, schema := range []interface{}{badLiteral{}, nowOnString{}, scalarOnSlice{}, wrongFuncType{},
+		intFuncOnString{}, nilFunc{}} {
+		func() {
+			defer func() {
+				err := recover()
+				if err == nil {
+					t.Errorf("registering %T did not panic", schema)
+				} else if msg, _ := err.(string); !strings.HasPrefix(msg, "Invalid default value") {
+					t.Errorf("registering %T: %v", schema, err)
+				}
+			}()
+			z := offlineSleep()
+			z.RegisterDefault("number", func() interface{} { return 42 })
+			z.RegisterDefault("nothing", func() interface{} { return nil })
+			z.Register(schema, "invalid")
+		}()
+	}
+}
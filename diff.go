@@ -0,0 +1,279 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strings"
+)
+
+// DiffOptions configures Sleep.Diff
+type DiffOptions struct {
+	// Samples is the number of documents sampled from each collection. Defaults to 100
+	Samples int
+}
+
+// SchemaDrift reports the differences between a registered schema and the documents and indexes of its collection
+type SchemaDrift struct {
+	Model      string
+	Collection string
+	// Documents is the number of documents that were sampled
+	Documents int
+	// ExtraFields lists fields found in the documents that the schema does not declare
+	ExtraFields []FieldDrift
+	// TypeMismatches lists fields whose stored type does not match the type declared in the schema
+	TypeMismatches []FieldDrift
+	// MissingIndexes lists indexes declared through struct tags that do not exist on the collection
+	MissingIndexes []mgo.Index
+}
+
+// FieldDrift reports a field of the sampled documents that does not match the schema
+type FieldDrift struct {
+	// Path is the dotted bson path of the field. Elements of arrays share the path of the array.
+	Path string
+	// Expected lists the bson types the schema allows, e.g. "string" or "objectId". It is empty for extra fields.
+	Expected []string
+	// Found is the bson type that was found
+	Found string
+	// Documents is the number of sampled documents with this difference and Sample the Id of one of them
+	Documents int
+	Sample    interface{}
+}
+
+// Diff samples documents from the collection of every registered model and reports fields that are present
+// in the data but not in the schema, fields stored with a different type than the one declared, and declared
+// indexes that do not exist. Only models with differences are reported. It is meant to catch drift caused by
+// other services writing to the same collections before it shows up as decode errors.
+//
+//	report, err := sleep.Diff(Sleep.DiffOptions{Samples: 500})
+//	for _, drift := range report {
+//		for _, field := range drift.TypeMismatches {
+//			fmt.Printf("%s.%s: expected %v, found %s\n", drift.Model, field.Path, field.Expected, field.Found)
+//		}
+//	}
+//
+// Types are compared to the model's JSONSchema. Documents are sampled with the $sample aggregation stage.
+func (z *Sleep) Diff(opts DiffOptions) ([]SchemaDrift, error) {
+	if opts.Samples == 0 {
+		opts.Samples = 100
+	}
+	report := []SchemaDrift{}
+	for _, model := range z.Models() {
+		if model.iface != nil {
+			continue
+		}
+		drift, err := model.diff(opts)
+		if err != nil {
+			return report, err
+		}
+		if len(drift.ExtraFields)+len(drift.TypeMismatches)+len(drift.MissingIndexes) != 0 {
+			report = append(report, drift)
+		}
+	}
+	return report, nil
+}
+
+func (m *Model) diff(opts DiffOptions) (SchemaDrift, error) {
+	drift := SchemaDrift{Model: m.name, Collection: m.C.Name}
+	names, err := m.C.Database.CollectionNames()
+	if err != nil {
+		return drift, err
+	}
+	exists := false
+	for _, name := range names {
+		exists = exists || name == m.C.Name
+	}
+	if !exists {
+		drift.MissingIndexes = m.indexes
+		return drift, nil
+	}
+
+	existing, err := m.C.Indexes()
+	if err != nil {
+		return drift, err
+	}
+	drift.MissingIndexes = missingIndexes(m.indexes, existing)
+
+	//only documents this model can see, including soft deleted ones
+	filter := m.Find(nil).WithDeleted().filter()
+	if filter == nil {
+		filter = bson.M{}
+	}
+	pipeline := []bson.M{{"$match": filter}, {"$sample": bson.M{"size": opts.Samples}}}
+	differ := &documentDiffer{fields: make(map[string]*FieldDrift)}
+	schema := m.documentSchema()
+	iter := m.C.Pipe(pipeline).Iter()
+	doc := bson.RawD{}
+	for iter.Next(&doc) {
+		drift.Documents++
+		differ.document(schema, doc)
+		doc = bson.RawD{}
+	}
+	err = iter.Close()
+	if err != nil {
+		return drift, err
+	}
+
+	for _, field := range differ.sorted() {
+		if field.Expected == nil {
+			drift.ExtraFields = append(drift.ExtraFields, *field)
+		} else {
+			drift.TypeMismatches = append(drift.TypeMismatches, *field)
+		}
+	}
+	return drift, nil
+}
+
+// missingIndexes returns the declared indexes that are not among the existing ones. Indexes are matched by
+// name when the declaration names them, and by key otherwise.
+func missingIndexes(indexes, existing []mgo.Index) []mgo.Index {
+	missing := []mgo.Index{}
+	for _, declared := range indexes {
+		found := false
+		for _, index := range existing {
+			if declared.Name != "" {
+				found = found || declared.Name == index.Name
+			} else {
+				found = found || strings.Join(declared.Key, ",") == strings.Join(index.Key, ",")
+			}
+		}
+		if !found {
+			missing = append(missing, declared)
+		}
+	}
+	return missing
+}
+
+// documentDiffer collects the differences found in the sampled documents
+type documentDiffer struct {
+	fields map[string]*FieldDrift
+	//id and seen belong to the document being compared
+	id   interface{}
+	seen map[string]bool
+}
+
+func (d *documentDiffer) document(schema bson.M, doc bson.RawD) {
+	d.id = nil
+	d.seen = make(map[string]bool)
+	for _, elem := range doc {
+		if elem.Name == "_id" {
+			elem.Value.Unmarshal(&d.id)
+		}
+	}
+	d.object(schema, doc, "")
+}
+
+func (d *documentDiffer) object(schema bson.M, doc bson.RawD, prefix string) {
+	properties, _ := schema["properties"].(bson.M)
+	additional, _ := schema["additionalProperties"].(bson.M)
+	for _, elem := range doc {
+		path := prefix + elem.Name
+		if properties == nil {
+			if additional != nil {
+				d.value(additional, elem.Value, path)
+			}
+			continue
+		}
+		property, ok := properties[elem.Name].(bson.M)
+		if !ok {
+			d.add(path, nil, bsonTypeNames[elem.Value.Kind])
+			continue
+		}
+		d.value(property, elem.Value, path)
+	}
+}
+
+func (d *documentDiffer) value(schema bson.M, raw bson.Raw, path string) {
+	found := bsonTypeNames[raw.Kind]
+	expected := allowedTypes(schema)
+	if expected != nil && !containsString(expected, found) {
+		d.add(path, expected, found)
+		return
+	}
+	switch raw.Kind {
+	case 0x03:
+		doc := bson.RawD{}
+		if raw.Unmarshal(&doc) == nil {
+			d.object(schema, doc, path+".")
+		}
+	case 0x04:
+		items, _ := schema["items"].(bson.M)
+		elems := []bson.Raw{}
+		if items != nil && raw.Unmarshal(&elems) == nil {
+			for _, elem := range elems {
+				d.value(items, elem, path)
+			}
+		}
+	}
+}
+
+// add counts a difference once per document
+func (d *documentDiffer) add(path string, expected []string, found string) {
+	key := path + " " + found
+	if d.seen[key] {
+		return
+	}
+	d.seen[key] = true
+	field, ok := d.fields[key]
+	if !ok {
+		field = &FieldDrift{Path: path, Expected: expected, Found: found, Sample: d.id}
+		d.fields[key] = field
+	}
+	field.Documents++
+}
+
+func (d *documentDiffer) sorted() []*FieldDrift {
+	keys := make([]string, 0, len(d.fields))
+	for key := range d.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := make([]*FieldDrift, len(keys))
+	for i, key := range keys {
+		fields[i] = d.fields[key]
+	}
+	return fields
+}
+
+// allowedTypes returns the bson types a JSON Schema allows, or nil if it allows any
+func allowedTypes(schema bson.M) []string {
+	switch kind := schema["bsonType"].(type) {
+	case string:
+		return []string{kind}
+	case []string:
+		return kind
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// bsonTypeNames maps bson element kinds to the type names used by $jsonSchema
+var bsonTypeNames = map[byte]string{
+	0x01: "double",
+	0x02: "string",
+	0x03: "object",
+	0x04: "array",
+	0x05: "binData",
+	0x06: "undefined",
+	0x07: "objectId",
+	0x08: "bool",
+	0x09: "date",
+	0x0A: "null",
+	0x0B: "regex",
+	0x0D: "javascript",
+	0x0F: "javascriptWithScope",
+	0x10: "int",
+	0x11: "timestamp",
+	0x12: "long",
+	0x13: "decimal",
+	0xFF: "minKey",
+	0x7F: "maxKey",
+}
@@ -0,0 +1,139 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testDrifted struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Name     string        `bson:"name"`
+	Age      int           `bson:"age"`
+	Address  struct {
+		City string `bson:"city"`
+	} `bson:"address"`
+	Tags []string         `bson:"tags"`
+	Meta map[string]int   `bson:"meta"`
+	Any  interface{}      `bson:"any"`
+	Refs []*bson.ObjectId `bson:"refs"`
+}
+
+func rawD(t *testing.T, doc bson.D) bson.RawD {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := bson.RawD{}
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDocumentDiffer(t *testing.T) {
+	z := offlineSleep()
+	schema := z.Register(testDrifted{}, "drifted").documentSchema()
+	first, second := bson.NewObjectId(), bson.NewObjectId()
+	ints := []string{"int", "long"}
+
+	tests := []struct {
+		name string
+		docs []bson.D
+		want []FieldDrift
+	}{
+		{"matching", []bson.D{{
+			{Name: "_id", Value: first}, {Name: "name", Value: "a"}, {Name: "age", Value: 3},
+			{Name: "address", Value: bson.D{{Name: "city", Value: "b"}}},
+			{Name: "tags", Value: []string{"c"}}, {Name: "meta", Value: bson.M{"d": 4}},
+			{Name: "any", Value: true}, {Name: "refs", Value: []interface{}{second, nil}},
+		}}, nil},
+		{"missing fields are not drift", []bson.D{{{Name: "_id", Value: first}}}, nil},
+		{"extra field", []bson.D{{{Name: "_id", Value: first}, {Name: "color", Value: "red"}}},
+			[]FieldDrift{{Path: "color", Found: "string", Documents: 1, Sample: first}}},
+		{"type mismatch", []bson.D{{{Name: "_id", Value: first}, {Name: "age", Value: "3"}}},
+			[]FieldDrift{{Path: "age", Expected: ints, Found: "string", Documents: 1, Sample: first}}},
+		{"nested object", []bson.D{{{Name: "_id", Value: first},
+			{Name: "address", Value: bson.D{{Name: "city", Value: 5}, {Name: "zip", Value: "x"}}}}},
+			[]FieldDrift{
+				{Path: "address.city", Expected: []string{"string"}, Found: "int", Documents: 1, Sample: first},
+				{Path: "address.zip", Found: "string", Documents: 1, Sample: first},
+			}},
+		{"object of the wrong type", []bson.D{{{Name: "_id", Value: first}, {Name: "address", Value: "b"}}},
+			[]FieldDrift{{Path: "address", Expected: []string{"object"}, Found: "string", Documents: 1, Sample: first}}},
+		{"array elements share the path of the array and count once", []bson.D{{{Name: "_id", Value: first},
+			{Name: "tags", Value: []interface{}{"a", 1, 2, 2.5}}}},
+			[]FieldDrift{
+				{Path: "tags", Expected: []string{"string"}, Found: "double", Documents: 1, Sample: first},
+				{Path: "tags", Expected: []string{"string"}, Found: "int", Documents: 1, Sample: first},
+			}},
+		{"map values", []bson.D{{{Name: "_id", Value: first}, {Name: "meta", Value: bson.M{"a": "x"}}}},
+			[]FieldDrift{{Path: "meta.a", Expected: ints, Found: "string", Documents: 1, Sample: first}}},
+		{"counted per document with the first as sample", []bson.D{
+			{{Name: "_id", Value: first}, {Name: "color", Value: "red"}},
+			{{Name: "_id", Value: second}, {Name: "color", Value: "blue"}, {Name: "size", Value: 1}},
+		}, []FieldDrift{
+			{Path: "color", Found: "string", Documents: 2, Sample: first},
+			{Path: "size", Found: "int", Documents: 1, Sample: second},
+		}},
+	}
+	for _, test := range tests {
+		differ := &documentDiffer{fields: make(map[string]*FieldDrift)}
+		for _, doc := range test.docs {
+			differ.document(schema, rawD(t, doc))
+		}
+		var got []FieldDrift
+		for _, field := range differ.sorted() {
+			got = append(got, *field)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAllowedTypes(t *testing.T) {
+	tests := []struct {
+		schema bson.M
+		want   []string
+	}{
+		{bson.M{"bsonType": "string"}, []string{"string"}},
+		{bson.M{"bsonType": []string{"int", "long", "null"}}, []string{"int", "long", "null"}},
+		{bson.M{}, nil},
+		{bson.M{"enum": []interface{}{"click"}}, nil},
+	}
+	for _, test := range tests {
+		if got := allowedTypes(test.schema); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.schema, got, test.want)
+		}
+	}
+}
+
+func TestMissingIndexes(t *testing.T) {
+	existing := []mgo.Index{
+		{Key: []string{"_id"}, Name: "_id_"},
+		{Key: []string{"name", "-age"}, Name: "name_1_age_-1"},
+		{Key: []string{"email"}, Name: "by_email"},
+	}
+	tests := []struct {
+		declared mgo.Index
+		missing  bool
+	}{
+		{mgo.Index{Key: []string{"name", "-age"}}, false},
+		{mgo.Index{Key: []string{"-age", "name"}}, true},
+		{mgo.Index{Key: []string{"name"}}, true},
+		{mgo.Index{Key: []string{"other"}, Name: "by_email"}, false},
+		{mgo.Index{Key: []string{"email"}, Name: "email_unique"}, true},
+	}
+	for _, test := range tests {
+		missing := missingIndexes([]mgo.Index{test.declared}, existing)
+		if (len(missing) != 0) != test.missing {
+			t.Errorf("%+v: got missing %v", test.declared, missing)
+		}
+	}
+	if missing := missingIndexes(nil, existing); len(missing) != 0 {
+		t.Errorf("got missing %v without declared indexes", missing)
+	}
+}
@@ -0,0 +1,233 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"sort"
+)
+
+// discriminator identifies the documents of one schema in a collection shared by several schemas
+type discriminator struct {
+	key   string
+	value string
+	index []int
+}
+
+// newDiscriminator finds the field tagged with `discriminator:"<value>"`. It returns nil if there is none.
+func newDiscriminator(typ reflect.Type) *discriminator {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		value := field.Tag.Get("discriminator")
+		if value == "" {
+			continue
+		}
+		if field.Type.Kind() != reflect.String {
+			panic("Discriminator field `" + typ.Name() + "." + field.Name + "` must be a string")
+		}
+		return &discriminator{key: bsonName(field), value: value, index: field.Index}
+	}
+	return nil
+}
+
+// set writes the discriminator value into a document of the schema
+func (d *discriminator) set(schema interface{}) {
+	if d == nil {
+		return
+	}
+	field := reflect.ValueOf(schema).Elem().FieldByIndex(d.index)
+	field.Set(reflect.ValueOf(d.value).Convert(field.Type()))
+}
+
+// RegisterInterface registers an interface type implemented by several schemas that share a collection.
+// It returns a model whose queries return documents of all of those schemas, each decoded into its own type.
+//
+// Each of the schemas is registered as usual, on the same collection, and has a string field tagged with
+// `discriminator:"<value>"`. Sleep fills in the field when documents are created or saved, and the models of
+// the individual schemas only ever see documents with their own value.
+//
+// Example:
+//
+//	type Event interface {
+//		Time() time.Time
+//	}
+//
+//	type ClickEvent struct {
+//		Sleep.Document `bson:"-"`
+//		Id     bson.ObjectId `bson:"_id"`
+//		Kind   string        `discriminator:"click"`
+//		At     time.Time
+//		Target string
+//	}
+//
+//	type PurchaseEvent struct {
+//		Sleep.Document `bson:"-"`
+//		Id     bson.ObjectId `bson:"_id"`
+//		Kind   string        `discriminator:"purchase"`
+//		At     time.Time
+//		Amount int
+//	}
+//
+//	Events := sleep.RegisterInterface((*Event)(nil), "events")
+//	Clicks := sleep.Register(ClickEvent{}, "events")
+//	sleep.Register(PurchaseEvent{}, "events")
+//
+//	events := []Event{}
+//	Events.Find(nil).Sort("-at").Limit(50).Exec(&events)  //*ClickEvent and *PurchaseEvent values
+//
+//	clicks := []*ClickEvent{}
+//	Clicks.Find(nil).Exec(&clicks)  //only click events
+//
+// Queries of the returned model apply the soft deletion of each schema, so WithDeleted and OnlyDeleted work as they
+// do on the models of the schemas. Documents whose discriminator value matches none of the registered schemas are
+// not returned.
+//
+// The argument must be a nil pointer to the interface type. Populate is not supported on queries of the returned model.
+// When a single interface value is passed to Exec and no document is found, it is left untouched.
+func (z *Sleep) RegisterInterface(iface interface{}, collectionName string) *Model {
+	typ := reflect.TypeOf(iface)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Interface {
+		panic("Expected a nil pointer to an interface type, e.g. (*Event)(nil)")
+	}
+	typ = typ.Elem()
+	model := newModel(z.Db.C(collectionName), z)
+	model.name = typ.Name()
+	model.iface = typ
+	model.schema = newSchema(model, typ)
+	z.models[model.name] = model
+
+	z.documents[model.name] = Document{C: z.Db.C(collectionName),
+		isQueried: true, Model: model,
+		Found: true}
+
+	return model
+}
+
+// subModels returns the models registered on the same collection with a discriminator, ordered by their value
+func (m *Model) subModels() []*Model {
+	subs := []*Model{}
+	for _, model := range m.z.models {
+		if model.discriminator != nil && model.C.FullName == m.C.FullName {
+			subs = append(subs, model)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].discriminator.value < subs[j].discriminator.value })
+	return subs
+}
+
+// interfaceFilter returns the condition selecting the documents of an interface model's schemas, each according
+// to its own soft deletion and the query's deleted mode
+func (m *Model) interfaceFilter(deleted int) interface{} {
+	or := []interface{}{}
+	for _, sub := range m.subModels() {
+		cond := M{sub.discriminator.key: sub.discriminator.value}
+		if sub.softDelete == nil {
+			//documents of schemas without soft deletion are never deleted
+			if deleted == onlyDeleted {
+				continue
+			}
+		} else if soft, ok := sub.softDelete.filter(deleted).(M); ok {
+			for key, val := range soft {
+				cond[key] = val
+			}
+		}
+		or = append(or, cond)
+	}
+	if len(or) == 0 {
+		//no document can be decoded, match none
+		return M{"_id": M{"$in": []interface{}{}}}
+	}
+	return M{"$or": or}
+}
+
+// subModel returns the model registered on the same collection with the given discriminator value
+func (m *Model) subModel(value string) *Model {
+	for _, model := range m.z.models {
+		if model.discriminator != nil && model.discriminator.value == value && model.C.FullName == m.C.FullName {
+			return model
+		}
+	}
+	return nil
+}
+
+// execInterface runs a query whose result is an interface value, or a slice of them, decoding every document
+// into the schema its discriminator names.
+func (query *Query) execInterface(q reader, result interface{}, ifaceType reflect.Type, isSlice bool) error {
+	model := query.model
+	if model == nil || model.iface != ifaceType {
+		model = query.z.models[ifaceType.Name()]
+	}
+	if model == nil || model.iface == nil {
+		panic("Unable to find interface `" + ifaceType.Name() + "`. Was it registered with RegisterInterface?")
+	}
+
+	raws := []bson.Raw{}
+	var err error
+	if isSlice {
+		err = q.All(&raws)
+	} else {
+		raw := bson.Raw{}
+		err = q.One(&raw)
+		raws = append(raws, raw)
+	}
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	resultVal := reflect.ValueOf(result).Elem()
+	if isSlice {
+		resultVal.Set(reflect.MakeSlice(resultVal.Type(), 0, len(raws)))
+	}
+	for _, raw := range raws {
+		doc, err := query.decodeInterface(model, raw, ifaceType)
+		if err != nil {
+			return err
+		}
+		if isSlice {
+			resultVal.Set(reflect.Append(resultVal, doc))
+		} else {
+			resultVal.Set(doc)
+		}
+	}
+	return nil
+}
+
+func (query *Query) decodeInterface(model *Model, raw bson.Raw, ifaceType reflect.Type) (reflect.Value, error) {
+	//any registered schema of the collection knows which key holds the discriminator
+	var key string
+	for _, sub := range query.z.models {
+		if sub.discriminator != nil && sub.C.FullName == model.C.FullName {
+			key = sub.discriminator.key
+			break
+		}
+	}
+	head := bson.M{}
+	err := raw.Unmarshal(&head)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	value, _ := head[key].(string)
+	sub := model.subModel(value)
+	if sub == nil {
+		return reflect.Value{}, fmt.Errorf("Sleep: no schema registered for discriminator %q of document %v in collection `%s`",
+			value, head["_id"], model.C.FullName)
+	}
+
+	schemaType := reflect.TypeOf(query.z.documents[sub.name].schemaStruct)
+	doc := reflect.New(schemaType)
+	if !doc.Type().Implements(ifaceType) {
+		panic("Schema `" + sub.name + "` does not implement `" + ifaceType.Name() + "`")
+	}
+	stored, err := sub.decodeRaw(raw, doc.Interface(), query.selection == nil)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	query.z.conditionDoc(doc.Interface())
+	document := documentOf(doc.Interface())
+	query.afterLoad(sub, document, stored)
+	return doc, nil
+}
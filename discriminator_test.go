@@ -0,0 +1,179 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testEvent interface {
+	When() time.Time
+}
+
+type testClick struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Kind     string        `bson:"kind" discriminator:"click"`
+	At       time.Time     `bson:"at"`
+	Target   string        `bson:"target"`
+}
+
+func (c *testClick) When() time.Time { return c.At }
+
+type testPurchase struct {
+	Document  `bson:"-"`
+	Id        bson.ObjectId `bson:"_id"`
+	Kind      string        `bson:"kind" discriminator:"purchase"`
+	At        time.Time     `bson:"at"`
+	Amount    int           `bson:"amount"`
+	DeletedAt *time.Time    `bson:"deletedAt" softdelete:"true"`
+}
+
+func (p *testPurchase) When() time.Time { return p.At }
+
+func registerEvents(z *Sleep) (events, clicks, purchases *Model) {
+	events = z.RegisterInterface((*testEvent)(nil), "events")
+	clicks = z.Register(testClick{}, "events")
+	purchases = z.Register(testPurchase{}, "events")
+	return
+}
+
+func TestDiscriminatorSet(t *testing.T) {
+	z := offlineSleep()
+	_, Clicks, _ := registerEvents(z)
+	click := &testClick{}
+	Clicks.CreateDoc(click)
+	if click.Kind != "click" {
+		t.Fatalf("got discriminator %q", click.Kind)
+	}
+	filter := Clicks.Find(bson.M{"target": "buy"}).filter()
+	want := M{"$and": []interface{}{bson.M{"target": "buy"}, M{"kind": "click"}}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Fatalf("got filter %#v", filter)
+	}
+}
+
+func TestRegisterInterfaceDocument(t *testing.T) {
+	z := offlineSleep()
+	registerEvents(z)
+	if _, ok := z.C("testEvent"); !ok {
+		t.Fatal("the interface model has no document template")
+	}
+}
+
+func TestInterfaceFilter(t *testing.T) {
+	z := offlineSleep()
+	Events, _, _ := registerEvents(z)
+	tests := []struct {
+		query  *Query
+		filter interface{}
+	}{
+		{Events.Find(nil), M{"$or": []interface{}{
+			M{"kind": "click"},
+			M{"kind": "purchase", "deletedAt": nil},
+		}}},
+		{Events.Find(nil).WithDeleted(), M{"$or": []interface{}{
+			M{"kind": "click"},
+			M{"kind": "purchase"},
+		}}},
+		{Events.Find(nil).OnlyDeleted(), M{"$or": []interface{}{
+			M{"kind": "purchase", "deletedAt": M{"$ne": nil}},
+		}}},
+	}
+	for i, test := range tests {
+		if got := test.query.filter(); !reflect.DeepEqual(got, test.filter) {
+			t.Errorf("%d: got filter %#v, want %#v", i, got, test.filter)
+		}
+	}
+
+	//with no schema registered for the collection nothing can be decoded
+	z = offlineSleep()
+	Events = z.RegisterInterface((*testEvent)(nil), "events")
+	want := M{"_id": M{"$in": []interface{}{}}}
+	if got := Events.Find(nil).filter(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got filter %#v", got)
+	}
+}
+
+func TestDecodeInterface(t *testing.T) {
+	z := offlineSleep()
+	Events, _, _ := registerEvents(z)
+	tests := []struct {
+		doc  bson.M
+		want reflect.Type
+	}{
+		{bson.M{"_id": bson.NewObjectId(), "kind": "click", "target": "buy"}, reflect.TypeOf(&testClick{})},
+		{bson.M{"_id": bson.NewObjectId(), "kind": "purchase", "amount": 3}, reflect.TypeOf(&testPurchase{})},
+	}
+	query := Events.Find(nil)
+	for _, test := range tests {
+		doc, err := query.decodeInterface(Events, rawDoc(t, test.doc), Events.iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Type() != test.want {
+			t.Errorf("got a %v, want a %v", doc.Type(), test.want)
+		}
+		if !documentOf(doc.Interface()).Found {
+			t.Errorf("%v was not conditioned as a found document", doc.Type())
+		}
+	}
+	_, err := query.decodeInterface(Events, rawDoc(t, bson.M{"kind": "refund"}), Events.iface)
+	if err == nil {
+		t.Error("a document with an unknown discriminator was decoded")
+	}
+}
+
+func TestInterfaceQuery(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Events, Clicks, Purchases := registerEvents(z)
+	now := time.Now()
+	click := &testClick{At: now, Target: "buy"}
+	Clicks.CreateDoc(click)
+	purchase := &testPurchase{At: now.Add(time.Second), Amount: 3}
+	Purchases.CreateDoc(purchase)
+	deleted := &testPurchase{At: now.Add(2 * time.Second), Amount: 5}
+	Purchases.CreateDoc(deleted)
+	for _, doc := range []interface{ Save() error }{click, purchase, deleted} {
+		if err := doc.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := deleted.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []testEvent{}
+	err := Events.Find(nil).Sort("at").Exec(&events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, a soft deleted document was returned", len(events))
+	}
+	if _, ok := events[0].(*testClick); !ok {
+		t.Errorf("got a %T first", events[0])
+	}
+	if p, ok := events[1].(*testPurchase); !ok || p.Amount != 3 {
+		t.Errorf("got %#v second", events[1])
+	}
+
+	err = Events.Find(nil).WithDeleted().Exec(&events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Errorf("got %d events including deleted ones", len(events))
+	}
+
+	clicks := []*testClick{}
+	err = Clicks.Find(nil).Exec(&clicks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clicks) != 1 {
+		t.Errorf("got %d clicks", len(clicks))
+	}
+}
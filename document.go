@@ -1,8 +1,8 @@
 package Sleep
 
 import (
-	"labix.org/v2/mgo"
-	"labix.org/v2/mgo/bson"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	"reflect"
 )
 
@@ -17,21 +17,49 @@ type Document struct {
 	schemaStruct interface{}
 	Found        bool
 	Virtual      *Virtual
+	//set if the document was upgraded from an older schema version when it was read
+	upgraded bool
+	//isNew is set for documents created with CreateDoc until they are first saved
+	isNew bool
 }
 
 // Save uses MongoDB's upsert command to either update an existing document or insert it into the collection.
 // The document's schma MUST have an Id field.
+//
+// The document's Validate hook is called after PreSave. If it returns an error the document is not saved
+// and the error is returned.
+//
+// Fields tagged with `sequence` are given their value on the first save. See Sleep.Sequence
+//
+// Documents upgraded from an older schema version are written in their new shape, or ErrUpgraded is returned if
+// write-back is disabled for the model. See Model.Upgrade
 func (d *Document) Save() error {
-	idField := reflect.ValueOf(d.schema).Elem().FieldByName("Id")
-	reflect.ValueOf(d.schema).MethodByName("PreSave").Call([]reflect.Value{})
-	id := idField.Interface()
-	_, err := d.C.UpsertId(id, d.schema)
+	op := &Operation{Op: OpSave, Model: d.Model, Document: d, Doc: d.schema}
+	return d.Model.z.run(op, d.save)
+}
 
+func (d *Document) save() error {
+	if d.upgraded && !d.Model.version.writeBack {
+		return ErrUpgraded
+	}
+	callHook(d.schema, "PreSave")
+	d.Model.discriminator.set(d.schema)
+	err := validate(d.schema)
 	if err != nil {
-		d.Found = true
-		reflect.ValueOf(d.schema).MethodByName("PostSave").Call([]reflect.Value{})
+		return err
 	}
-	return err
+	err = d.Model.assignSequences(d.schema)
+	if err != nil {
+		return err
+	}
+	_, err = d.Model.C.UpsertId(docId(d.schema), d.schema)
+	if err != nil {
+		return err
+	}
+	d.Found = true
+	d.isNew = false
+	callHook(d.schema, "PostSave")
+	return nil
 }
 
 // Use this method to check if this document is in fact populated with data from the database.
@@ -110,34 +138,89 @@ func (d *Document) Populated(path string, result interface{}) bool {
 }
 
 // Removes the document from the database
+//
+// If the document's schema opted into soft deletion, the document is kept and its deleted marker is set instead.
+// Soft deleted documents are left out of query results unless Query.WithDeleted or Query.OnlyDeleted is used,
+// and can be brought back with Restore.
+//
+//	type User struct {
+//		Sleep.Document `bson:"-"`
+//		Id        bson.ObjectId `bson:"_id"`
+//		DeletedAt *time.Time    `softdelete:"true" retain:"2160h"` //purged 90 days after deletion
+//	}
+//
+// Fields of other registered models that reference this document can declare what happens to them
+// when it is removed using the `ondelete` tag:
+//
+//		cascade  - the referencing documents are removed too, using their own Remove method
+//		nullify  - the reference is unset, or pulled from the slice of references
+//		restrict - Remove fails with ErrReferenced as long as any document references this one
+//
+// The restrict rule is checked before the PreRemove hook is called. Soft deleting a document checks it too,
+// but leaves the references to it alone so that Restore brings everything back. They are nullified and
+// cascaded once the document is removed for good with Model.ForceRemoveId or Model.PurgeDeleted.
+//
+//	type Post struct {
+//		Sleep.Document `bson:"-"`
+//		Id     bson.ObjectId   `bson:"_id"`
+//		Author bson.ObjectId   `model:"User" ondelete:"cascade"`
+//		Likes  []bson.ObjectId `model:"User" ondelete:"nullify"`
+//	}
 func (d *Document) Remove() error {
-	reflect.ValueOf(d.schema).MethodByName("PreRemove").Call([]reflect.Value{})
-	id := reflect.ValueOf(d.schema).Elem().FieldByName("Id").Interface().(bson.ObjectId)
-	err := d.C.Remove(bson.M{"_id": id})
-	//if we want it gone and it's already gone, should we really freak out?
-	if err == mgo.ErrNotFound {
-		err = nil
-	}
+	op := &Operation{Op: OpRemove, Model: d.Model, Document: d, Doc: d.schema, Id: docId(d.schema)}
+	return d.Model.z.run(op, d.remove)
+}
 
+func (d *Document) remove() error {
+	id := docId(d.schema)
+	err := d.Model.checkRestrict(id, make(map[refKey]bool))
 	if err != nil {
-		reflect.ValueOf(d.schema).MethodByName("PostRemove").Call([]reflect.Value{})
+		return err
 	}
-	return err
+	callHook(d.schema, "PreRemove")
+	if d.Model.softDelete != nil {
+		_, err = d.Model.C.UpdateAll(bson.M{"_id": id}, d.Model.softDelete.mark(d.schema))
+	} else {
+		err = d.Model.C.RemoveId(id)
+		//if we want it gone and it's already gone, should we really freak out?
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+		if err == nil {
+			err = d.Model.applyDeleteRules(id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	callHook(d.schema, "PostRemove")
+	return nil
 }
 
 //implement Apply function here
 // it takes care of applying changes/merging to the document from another document
 func (d *Document) Apply(update interface{}) error {
+	op := &Operation{Op: OpUpdate, Model: d.Model, Document: d, Doc: d.schema, Id: docId(d.schema), Change: update}
+	return d.Model.z.run(op, func() error {
+		return d.apply(op.Change)
+	})
+}
+
+func (d *Document) apply(update interface{}) error {
 	change := mgo.Change{
 		Update:    update,
 		Upsert:    true,
 		ReturnNew: true}
 
-	id := reflect.ValueOf(d.schema).Elem().FieldByName("Id").Interface().(bson.ObjectId)
-	_, err := d.C.FindId(id).Apply(change, d.schema)
+	_, err := d.C.FindId(docId(d.schema)).Apply(change, d.schema)
 	return err
 }
 
+// validate calls the Validate hook of a schema
+func validate(schema interface{}) error {
+	return callHook(schema, "Validate")
+}
+
 // implement populate function here so that  a document is able to be populated
 // after the initial query for its value
 
@@ -159,6 +242,15 @@ func (d *Document) PostSave() {
 
 }
 
+// Validate is a stand-in method that can be implemented in the schema defination struct
+// to check the document before it is saved to the database. Returning an error prevents the save.
+// It is called after PreSave by Document.Save and by bulk writes.
+//
+// The method should have a reciever that is a pointer to the schema type
+func (d *Document) Validate() error {
+	return nil
+}
+
 // PreRemove is a stand-in method that can be implemented in the schema defination struct
 // to be called before the document is removed from the database.
 //
@@ -0,0 +1,149 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// Plan is a summary of the query plan chosen by the server for a query.
+// It is returned by Query.Explain
+type Plan struct {
+	// Stage is the stage of the winning plan that reads the documents, e.g. "COLLSCAN" or "IXSCAN".
+	// It is "COLLSCAN" if any branch of a plan with several inputs, like an OR, scans the collection.
+	Stage string
+	// Index is the name of the index used by the winning plan. It is empty if no index was used
+	Index string
+	// KeysExamined is the number of index entries scanned
+	KeysExamined int
+	// DocsExamined is the number of documents scanned
+	DocsExamined int
+	// Returned is the number of documents returned
+	Returned int
+	// Raw holds the full output of the explain command as returned by the server
+	Raw bson.M
+}
+
+// IsCollScan reports whether the plan performs a full collection scan
+func (p *Plan) IsCollScan() bool {
+	return p.Stage == "COLLSCAN"
+}
+
+// Explain asks the server how it would execute the query and returns a summary of the winning plan.
+// The query is not executed and no populate operations are performed.
+//
+// Example:
+//
+//		plan, err := sleep.Find(bson.M{"age": 40}).Sort("name").Explain()
+//		if err == nil && plan.IsCollScan() {
+//			//add an index!
+//		}
+func (query *Query) Explain() (*Plan, error) {
+	c, release := query.collection()
+	defer release()
+	raw := bson.M{}
+	err := query.reader(c).Explain(raw)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlan(raw), nil
+}
+
+// warnCollScan logs a warning if the query would perform a collection scan. Only called in development mode
+func (query *Query) warnCollScan() {
+	plan, err := query.Explain()
+	if err != nil {
+		query.z.logger.Printf("unable to explain query %v on `%s`: %v", query.query, query.c.FullName, err)
+		return
+	}
+	if !plan.IsCollScan() {
+		return
+	}
+	if query.isPopOp {
+		query.z.logger.Printf("populate of `%s` performs a collection scan on `%s`. Query: %v", query.path, query.c.FullName, query.query)
+		return
+	}
+	query.z.logger.Printf("query performs a collection scan on `%s`. Query: %v", query.c.FullName, query.query)
+}
+
+// parsePlan reads both the explain output of MongoDB 3.0+ (queryPlanner/executionStats), including
+// that of aggregations, and the legacy output of older servers (cursor/nscanned)
+func parsePlan(raw bson.M) *Plan {
+	plan := &Plan{Raw: raw}
+
+	explained := raw
+	//aggregations, like the ones run by Near queries, may report the plan of their first stage under $cursor
+	if stages, ok := raw["stages"].([]interface{}); ok && len(stages) != 0 {
+		if first, ok := stages[0].(bson.M); ok {
+			if cursor, ok := first["$cursor"].(bson.M); ok {
+				explained = cursor
+			}
+		}
+	}
+	if planner, ok := explained["queryPlanner"].(bson.M); ok {
+		if stage, ok := planner["winningPlan"].(bson.M); ok {
+			walkPlan(plan, stage)
+		}
+		if stats, ok := explained["executionStats"].(bson.M); ok {
+			plan.Returned = toInt(stats["nReturned"])
+			plan.DocsExamined = toInt(stats["totalDocsExamined"])
+			plan.KeysExamined = toInt(stats["totalKeysExamined"])
+		}
+		return plan
+	}
+
+	cursor, _ := raw["cursor"].(string)
+	if strings.HasPrefix(cursor, "BasicCursor") {
+		plan.Stage = "COLLSCAN"
+	} else if strings.HasPrefix(cursor, "BtreeCursor") {
+		plan.Stage = "IXSCAN"
+		plan.Index = strings.TrimSpace(strings.TrimPrefix(cursor, "BtreeCursor"))
+	} else {
+		plan.Stage = cursor
+	}
+	plan.Returned = toInt(raw["n"])
+	plan.DocsExamined = toInt(raw["nscannedObjects"])
+	plan.KeysExamined = toInt(raw["nscanned"])
+	return plan
+}
+
+// walkPlan walks down to the stages that actually read from the collection or an index. Stages with
+// several inputs, like OR, report a collection scan if any of their branches scans the collection.
+func walkPlan(plan *Plan, stage bson.M) {
+	if name, ok := stage["indexName"].(string); ok && plan.Index == "" {
+		plan.Index = name
+	}
+	inputs := []interface{}{}
+	if input, ok := stage["inputStage"].(bson.M); ok {
+		inputs = append(inputs, input)
+	}
+	if list, ok := stage["inputStages"].([]interface{}); ok {
+		inputs = append(inputs, list...)
+	}
+	if len(inputs) == 0 {
+		name, _ := stage["stage"].(string)
+		if plan.Stage == "" || name == "COLLSCAN" {
+			plan.Stage = name
+		}
+		return
+	}
+	for _, input := range inputs {
+		if input, ok := input.(bson.M); ok {
+			walkPlan(plan, input)
+		}
+	}
+}
+
+// toInt converts the numeric types the server may return into an int
+func toInt(val interface{}) int {
+	switch n := val.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
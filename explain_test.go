@@ -0,0 +1,72 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestParsePlanIndexScan(t *testing.T) {
+	raw := bson.M{
+		"queryPlanner": bson.M{"winningPlan": bson.M{"stage": "FETCH",
+			"inputStage": bson.M{"stage": "IXSCAN", "indexName": "age_1"}}},
+		"executionStats": bson.M{"nReturned": 3, "totalDocsExamined": int64(3), "totalKeysExamined": 4.0},
+	}
+	plan := parsePlan(raw)
+	if plan.Stage != "IXSCAN" || plan.Index != "age_1" || plan.IsCollScan() {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if plan.Returned != 3 || plan.DocsExamined != 3 || plan.KeysExamined != 4 {
+		t.Fatalf("unexpected stats %+v", plan)
+	}
+}
+
+func TestParsePlanOrWithCollScan(t *testing.T) {
+	raw := bson.M{"queryPlanner": bson.M{"winningPlan": bson.M{"stage": "SUBPLAN",
+		"inputStage": bson.M{"stage": "FETCH", "inputStage": bson.M{"stage": "OR",
+			"inputStages": []interface{}{
+				bson.M{"stage": "IXSCAN", "indexName": "name_1"},
+				bson.M{"stage": "COLLSCAN"},
+			}}}}}}
+	plan := parsePlan(raw)
+	if !plan.IsCollScan() {
+		t.Fatalf("expected a collection scan, got %+v", plan)
+	}
+	if plan.Index != "name_1" {
+		t.Fatalf("expected index name_1, got %q", plan.Index)
+	}
+}
+
+func TestParsePlanOrWithIndexes(t *testing.T) {
+	raw := bson.M{"queryPlanner": bson.M{"winningPlan": bson.M{"stage": "OR",
+		"inputStages": []interface{}{
+			bson.M{"stage": "IXSCAN", "indexName": "name_1"},
+			bson.M{"stage": "IXSCAN", "indexName": "age_1"},
+		}}}}
+	plan := parsePlan(raw)
+	if plan.IsCollScan() || plan.Stage != "IXSCAN" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
+
+func TestParsePlanLegacy(t *testing.T) {
+	plan := parsePlan(bson.M{"cursor": "BasicCursor", "n": 2, "nscannedObjects": 10, "nscanned": 10})
+	if !plan.IsCollScan() || plan.Returned != 2 || plan.DocsExamined != 10 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	plan = parsePlan(bson.M{"cursor": "BtreeCursor age_1"})
+	if plan.Stage != "IXSCAN" || plan.Index != "age_1" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
+
+func TestParsePlanAggregation(t *testing.T) {
+	raw := bson.M{"stages": []interface{}{
+		bson.M{"$cursor": bson.M{"queryPlanner": bson.M{"winningPlan": bson.M{"stage": "GEO_NEAR_2DSPHERE",
+			"indexName": "location_2dsphere"}}}},
+		bson.M{"$limit": 10},
+	}}
+	plan := parsePlan(raw)
+	if plan.Stage != "GEO_NEAR_2DSPHERE" || plan.Index != "location_2dsphere" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
@@ -0,0 +1,171 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// distanceKey is the key the server stores the distance to each Query.Near result under
+const distanceKey = "_distance"
+
+// Point is a GeoJSON point. Use it as a field type in schemas to store locations.
+// Coordinates are stored in longitude, latitude order.
+//
+// Tag the field with `geo:"2dsphere"` to declare a 2dsphere index on it. See Model.EnsureIndexes
+type Point struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewPoint returns a GeoJSON point for the given longitude and latitude
+func NewPoint(lng, lat float64) Point {
+	return Point{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// Lng returns the point's longitude. It is 0 if the point has no coordinates.
+func (p Point) Lng() float64 {
+	if len(p.Coordinates) < 2 {
+		return 0
+	}
+	return p.Coordinates[0]
+}
+
+// Lat returns the point's latitude. It is 0 if the point has no coordinates.
+func (p Point) Lat() float64 {
+	if len(p.Coordinates) < 2 {
+		return 0
+	}
+	return p.Coordinates[1]
+}
+
+// Polygon is a GeoJSON polygon. The first ring is the outer boundary, any further rings are holes.
+type Polygon struct {
+	Type        string        `bson:"type" json:"type"`
+	Coordinates [][][]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewPolygon returns a GeoJSON polygon with the given points as its outer boundary.
+// The ring is closed automatically if the last point is not the same as the first.
+func NewPolygon(points ...Point) Polygon {
+	ring := make([][]float64, 0, len(points)+1)
+	for _, point := range points {
+		ring = append(ring, []float64{point.Lng(), point.Lat()})
+	}
+	if len(points) != 0 {
+		first, last := points[0], points[len(points)-1]
+		if first.Lng() != last.Lng() || first.Lat() != last.Lat() {
+			ring = append(ring, []float64{first.Lng(), first.Lat()})
+		}
+	}
+	return Polygon{Type: "Polygon", Coordinates: [][][]float64{ring}}
+}
+
+// geoNear describes the origin of a Near query. Near queries run as an aggregation with a $geoNear stage
+// so the server returns the distance to each result.
+type geoNear struct {
+	field       string
+	point       Point
+	maxDistance float64
+}
+
+// Near limits the results to documents whose point stored in field is within maxDistance meters of the
+// given point, sorted nearest first. Pass 0 as maxDistance for no limit. The field is the bson key
+// of the location field and requires a 2dsphere index.
+//
+// The distance in meters from the point to each result is computed by the server and stored in its Virtual
+// as a float named "distance".
+//
+//	type Store struct {
+//		Sleep.Document `bson:"-"`
+//		Id       bson.ObjectId `bson:"_id"`
+//		Name     string
+//		Location Sleep.Point   `geo:"2dsphere"`
+//	}
+//
+//	stores := []*Store{}
+//	Store.Find(nil).Near("location", Sleep.NewPoint(-73.98, 40.75), 5000).Limit(10).Exec(&stores)
+//	meters, _ := stores[0].Virtual.GetFloat("distance")
+//
+// The query runs as an aggregation pipeline starting with a $geoNear stage, which requires MongoDB 4.0
+// or newer. Hint, MaxTime and Comment do not apply to it, and Near can not be combined with Search.
+func (q *Query) Near(field string, point Point, maxDistance float64) *Query {
+	q = q.Clone()
+	q.near = &geoNear{field: field, point: point, maxDistance: maxDistance}
+	q.virtuals[distanceKey] = "distance"
+	return q
+}
+
+// Within limits the results to documents whose location stored in field lies within the polygon.
+// The field is the bson key of the location field.
+//
+//	Store.Find(nil).Within("location", Sleep.NewPolygon(a, b, c, d)).Exec(&stores)
+func (q *Query) Within(field string, polygon Polygon) *Query {
+	return q.Where(M{field: M{"$geoWithin": M{"$geometry": polygon}}})
+}
+
+// pipeline returns the aggregation pipeline that runs a Near query with the given filter and options
+func (g *geoNear) pipeline(query *Query, filter interface{}) []M {
+	if filter == nil {
+		filter = M{}
+	}
+	stage := M{"near": g.point, "key": g.field, "distanceField": distanceKey, "spherical": true, "query": filter}
+	if g.maxDistance > 0 {
+		stage["maxDistance"] = g.maxDistance
+	}
+	pipeline := []M{{"$geoNear": stage}}
+	if len(query.sort) != 0 {
+		pipeline = append(pipeline, M{"$sort": sortDoc(query.sort)})
+	}
+	if query.skip != 0 {
+		pipeline = append(pipeline, M{"$skip": query.skip})
+	}
+	if query.limit != 0 {
+		pipeline = append(pipeline, M{"$limit": query.limit})
+	}
+	if query.selection != nil {
+		selection := mergeSelection(query.selection, nil)
+		if isInclusion(selection) {
+			selection[distanceKey] = 1
+		}
+		pipeline = append(pipeline, M{"$project": selection})
+	}
+	return pipeline
+}
+
+// sortDoc converts sort fields in the form accepted by Query.Sort, e.g. "-age", into a $sort document
+func sortDoc(fields []string) bson.D {
+	doc := bson.D{}
+	for _, field := range fields {
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			order = -1
+			field = field[1:]
+		} else if strings.HasPrefix(field, "+") {
+			field = field[1:]
+		}
+		doc = append(doc, bson.DocElem{Name: field, Value: order})
+	}
+	return doc
+}
+
+// isInclusion reports whether a projection lists the fields to return rather than the fields to leave out
+func isInclusion(selection M) bool {
+	for key, val := range selection {
+		if key == "_id" {
+			continue
+		}
+		switch v := val.(type) {
+		case bool:
+			return v
+		case int:
+			return v != 0
+		case int64:
+			return v != 0
+		case float64:
+			return v != 0
+		default:
+			return true
+		}
+	}
+	return false
+}
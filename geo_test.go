@@ -0,0 +1,95 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testStore struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Name     string
+	Location Point `geo:"2dsphere"`
+}
+
+func TestEmptyPoint(t *testing.T) {
+	var p Point
+	if p.Lng() != 0 || p.Lat() != 0 {
+		t.Errorf("got %v, %v for an empty point", p.Lng(), p.Lat())
+	}
+}
+
+func TestNewPolygonClosesRing(t *testing.T) {
+	polygon := NewPolygon(NewPoint(0, 0), NewPoint(1, 0), NewPoint(1, 1))
+	ring := polygon.Coordinates[0]
+	if len(ring) != 4 || !reflect.DeepEqual(ring[0], ring[3]) {
+		t.Errorf("ring is not closed: %v", ring)
+	}
+	polygon = NewPolygon(NewPoint(0, 0), NewPoint(1, 0), NewPoint(1, 1), NewPoint(0, 0))
+	if len(polygon.Coordinates[0]) != 4 {
+		t.Errorf("closed ring was closed again: %v", polygon.Coordinates[0])
+	}
+}
+
+func TestNearPipeline(t *testing.T) {
+	z := offlineSleep()
+	Stores := z.Register(testStore{}, "stores")
+	point := NewPoint(-73.98, 40.75)
+	query := Stores.Find(M{"name": "a"}).Near("location", point, 5000).Sort("-name").Skip(5).Limit(10).Select(M{"name": 1})
+
+	got := query.near.pipeline(query, query.filter())
+	want := []M{
+		{"$geoNear": M{"near": point, "key": "location", "distanceField": distanceKey, "spherical": true,
+			"query": M{"name": "a"}, "maxDistance": 5000.0}},
+		{"$sort": bson.D{{Name: "name", Value: -1}}},
+		{"$skip": 5},
+		{"$limit": 10},
+		{"$project": M{"name": 1, distanceKey: 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got pipeline %#v, want %#v", got, want)
+	}
+
+	query = Stores.Find(nil).Near("location", point, 0).Select(M{"name": 0})
+	got = query.near.pipeline(query, query.filter())
+	want = []M{
+		{"$geoNear": M{"near": point, "key": "location", "distanceField": distanceKey, "spherical": true, "query": M{}}},
+		{"$project": M{"name": 0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got pipeline %#v, want %#v", got, want)
+	}
+}
+
+func TestNearDistance(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Stores := z.Register(testStore{}, "stores")
+	if err := Stores.EnsureIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []*testStore{
+		{Name: "far", Location: NewPoint(-73.90, 40.75)},
+		{Name: "near", Location: NewPoint(-73.98, 40.75)},
+	} {
+		Stores.CreateDoc(store)
+		if err := store.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stores := []*testStore{}
+	err := Stores.Find(nil).Near("location", NewPoint(-73.98, 40.76), 0).Exec(&stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stores) != 2 || stores[0].Name != "near" {
+		t.Fatalf("got %d stores, want near first", len(stores))
+	}
+	first, ok1 := stores[0].Virtual.GetFloat("distance")
+	second, ok2 := stores[1].Virtual.GetFloat("distance")
+	if !ok1 || !ok2 || first <= 0 || second <= first {
+		t.Errorf("got distances %v and %v", first, second)
+	}
+}
@@ -0,0 +1,122 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+)
+
+var objectIdType = reflect.TypeOf(bson.ObjectId(""))
+
+// SetIdGenerator sets the function that creates the Id of new documents of the model.
+// The value returned must be assignable to the schema's Id field.
+//
+// Schemas with an Id of type bson.ObjectId get a new ObjectId by default. Other Id types, such as
+// strings, integers, UUIDs or composite struct Ids, are left untouched unless a generator is set.
+//
+//	type Invoice struct {
+//		Sleep.Document `bson:"-"`
+//		Id     string `bson:"_id"`
+//		Amount int
+//	}
+//
+//	Invoice := sleep.Register(Invoice{}, "invoices")
+//	Invoice.SetIdGenerator(func() interface{} {
+//		return uuid.New().String()
+//	})
+func (m *Model) SetIdGenerator(fn func() interface{}) {
+	m.idGenerator = fn
+}
+
+// newId returns a new Id for a document of the model. It returns false if the model has no way of creating Ids
+func (m *Model) newId() (reflect.Value, bool) {
+	if m.idGenerator != nil {
+		return reflect.ValueOf(m.idGenerator()), true
+	}
+	if m.idType == objectIdType {
+		return reflect.ValueOf(bson.NewObjectId()), true
+	}
+	return reflect.Value{}, false
+}
+
+// assignId sets a new Id on a document, if the model has a way of creating Ids
+func (m *Model) assignId(doc interface{}) {
+	id, ok := m.newId()
+	if !ok {
+		return
+	}
+	if accessors, ok := doc.(Accessors); ok {
+		accessors.SleepSetId(id.Interface())
+		return
+	}
+	if info := infoOf(doc); info != nil {
+		reflect.ValueOf(doc).Elem().FieldByIndex(info.id).Set(id)
+		return
+	}
+	reflect.ValueOf(doc).Elem().FieldByName("Id").Set(id)
+}
+
+// id converts an Id passed to one of the model's methods into the value stored in the database.
+// Strings are accepted for models with ObjectId Ids, every other value is used as is.
+func (m *Model) id(id interface{}) interface{} {
+	if m.idType == objectIdType {
+		return getObjectId(id)
+	}
+	return id
+}
+
+// docId returns the Id of a document
+func docId(schema interface{}) interface{} {
+	if accessors, ok := schema.(Accessors); ok {
+		return accessors.SleepId()
+	}
+	if info := infoOf(schema); info != nil {
+		return reflect.ValueOf(schema).Elem().FieldByIndex(info.id).Interface()
+	}
+	return reflect.ValueOf(schema).Elem().FieldByName("Id").Interface()
+}
+
+// isIdSlice reports whether a reference field holds a slice of Ids rather than a single one.
+// Byte slices are taken to be a single binary Id.
+func isIdSlice(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8
+}
+
+// referencedIds returns the Ids held by a reference field, which may be a single Id or a slice of them.
+// Zero valued Ids are left out.
+func referencedIds(field reflect.Value) []interface{} {
+	if !isIdSlice(field.Type()) {
+		if field.IsZero() {
+			return nil
+		}
+		return []interface{}{field.Interface()}
+	}
+	ids := make([]interface{}, 0, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		if elem := field.Index(i); !elem.IsZero() {
+			ids = append(ids, elem.Interface())
+		}
+	}
+	return ids
+}
+
+// idKey returns a string identifying an Id, for use as a map key. Ids can be of any type, including documents and
+// byte slices that can not be map keys themselves. Ids that are stored the same way get the same key.
+//
+// Composite Ids read from the database must be decoded into bson.D values, which keep the order of their fields.
+func idKey(id interface{}) string {
+	data, err := bson.Marshal(bson.M{"id": id})
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// lookup returns the value of a field of a document decoded into a bson.D, or nil if it has no such field
+func lookup(doc bson.D, name string) interface{} {
+	for _, elem := range doc {
+		if elem.Name == name {
+			return elem.Value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testInvoiceId struct {
+	Year   int `bson:"year"`
+	Number int `bson:"number"`
+}
+
+type testInvoice struct {
+	Document `bson:"-"`
+	Id       testInvoiceId `bson:"_id"`
+	Amount   int
+}
+
+type testLine struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Invoice  testInvoiceId `model:"testInvoice" ondelete:"restrict"`
+}
+
+type testBlob struct {
+	Document `bson:"-"`
+	Id       []byte `bson:"_id"`
+}
+
+type testBlobRef struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Blob     []byte        `model:"testBlob" ondelete:"restrict"`
+}
+
+type testCode struct {
+	Document `bson:"-"`
+	Id       string `bson:"_id"`
+}
+
+func TestIdGenerators(t *testing.T) {
+	z := offlineSleep()
+	People := z.Register(testPerson{}, "people")
+	Codes := z.Register(testCode{}, "codes")
+
+	person := &testPerson{}
+	People.CreateDoc(person)
+	if !person.Id.Valid() {
+		t.Fatal("ObjectId Ids are not generated by default")
+	}
+
+	code := &testCode{}
+	Codes.CreateDoc(code)
+	if code.Id != "" {
+		t.Fatalf("got Id %q without a generator", code.Id)
+	}
+	n := 0
+	Codes.SetIdGenerator(func() interface{} {
+		n++
+		return fmt.Sprintf("code-%d", n)
+	})
+	Codes.CreateDoc(code)
+	if code.Id != "code-1" {
+		t.Fatalf("got Id %q, want code-1", code.Id)
+	}
+
+	hex := bson.NewObjectId().Hex()
+	if People.id(hex) != bson.ObjectIdHex(hex) || Codes.id("abc") != "abc" {
+		t.Error("Ids passed to model methods are not converted to the stored type")
+	}
+}
+
+func TestIdKey(t *testing.T) {
+	id := testInvoiceId{2024, 7}
+	//composite Ids read from the database are decoded as bson.D
+	data, _ := bson.Marshal(bson.M{"_id": id})
+	stored := bson.D{}
+	bson.Unmarshal(data, &stored)
+	if idKey(id) != idKey(lookup(stored, "_id")) {
+		t.Error("a composite Id and its stored form have different keys")
+	}
+	if idKey(id) == idKey(testInvoiceId{2024, 8}) {
+		t.Error("different Ids have the same key")
+	}
+	if idKey([]byte{1, 2}) != idKey([]byte{1, 2}) || idKey([]byte{1, 2}) == idKey([]byte{1, 3}) {
+		t.Error("binary Ids are not keyed by their contents")
+	}
+	oid := bson.NewObjectId()
+	if idKey(oid) == idKey(oid.Hex()) {
+		t.Error("an ObjectId and a string have the same key")
+	}
+}
+
+func TestReferenceIds(t *testing.T) {
+	doc := bson.D{{Name: "a", Value: bson.D{{Name: "b", Value: []interface{}{1, 2}}}}, {Name: "c", Value: 3}}
+	if ids := referenceIds(doc, "a.b"); !reflect.DeepEqual(ids, []interface{}{1, 2}) {
+		t.Errorf("got %v", ids)
+	}
+	if ids := referenceIds(doc, "c"); !reflect.DeepEqual(ids, []interface{}{3}) {
+		t.Errorf("got %v", ids)
+	}
+	if ids := referenceIds(doc, "c.d"); ids != nil {
+		t.Errorf("got %v", ids)
+	}
+}
+
+func TestCompositeAndBinaryIds(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Invoices := z.Register(testInvoice{}, "invoices")
+	Lines := z.Register(testLine{}, "lines")
+	Blobs := z.Register(testBlob{}, "blobs")
+	BlobRefs := z.Register(testBlobRef{}, "blobRefs")
+
+	invoice := &testInvoice{Id: testInvoiceId{2024, 1}}
+	blob := &testBlob{Id: []byte{1, 2, 3}}
+	Invoices.CreateDoc(invoice)
+	Blobs.CreateDoc(blob)
+	line := &testLine{Invoice: invoice.Id}
+	dangling := &testLine{Invoice: testInvoiceId{2024, 2}}
+	Lines.CreateDoc(line)
+	Lines.CreateDoc(dangling)
+	blobRef := &testBlobRef{Blob: blob.Id}
+	danglingBlob := &testBlobRef{Blob: []byte{9}}
+	BlobRefs.CreateDoc(blobRef)
+	BlobRefs.CreateDoc(danglingBlob)
+	for _, doc := range []interface{ Save() error }{invoice, blob, line, dangling, blobRef, danglingBlob} {
+		if err := doc.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := invoice.Remove(); err != ErrReferenced {
+		t.Errorf("got %v removing a referenced invoice, want ErrReferenced", err)
+	}
+	if err := blob.Remove(); err != ErrReferenced {
+		t.Errorf("got %v removing a referenced blob, want ErrReferenced", err)
+	}
+
+	report, err := z.CheckReferences(CheckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]int{}
+	for _, r := range report {
+		found[r.Model+"."+r.Field] = r.References
+	}
+	if found["testLine.Invoice"] != 1 || found["testBlobRef.Blob"] != 1 || len(found) != 2 {
+		t.Errorf("got report %+v", report)
+	}
+}
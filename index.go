@@ -0,0 +1,80 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"reflect"
+	"strconv"
+)
+
+// newIndexes builds the indexes declared through struct tags on a schema.
+//
+// Fields tagged with `text:"<weight>"` make up the collection's text index.
+// Fields tagged with `geo:"2dsphere"` get a 2dsphere index each.
+func newIndexes(typ reflect.Type) []mgo.Index {
+	indexes := []mgo.Index{}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		geo := field.Tag.Get("geo")
+		if geo == "" {
+			continue
+		}
+		if geo != "2dsphere" {
+			panic("Unsupported index type `" + geo + "` on field `" + typ.Name() + "." + field.Name + "`")
+		}
+		indexes = append(indexes, mgo.Index{Key: []string{"$2dsphere:" + bsonName(field)}})
+	}
+
+	text := mgo.Index{Name: "sleep_text", Weights: make(map[string]int)}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		weight := field.Tag.Get("text")
+		if weight == "" {
+			continue
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 1 {
+			panic("The `text` tag on field `" + typ.Name() + "." + field.Name + "` must be a positive weight")
+		}
+		name := bsonName(field)
+		text.Key = append(text.Key, "$text:"+name)
+		text.Weights[name] = n
+	}
+	if len(text.Key) != 0 {
+		indexes = append(indexes, text)
+	}
+
+	return indexes
+}
+
+// Indexes returns the indexes declared on the model's schema through struct tags
+func (m *Model) Indexes() []mgo.Index {
+	return m.indexes
+}
+
+// EnsureIndexes creates the indexes declared on the model's schema through struct tags.
+// Indexes that already exist are left untouched.
+//
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Collection.EnsureIndex
+func (m *Model) EnsureIndexes() error {
+	for _, index := range m.indexes {
+		err := m.C.EnsureIndex(index)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureIndexes creates the indexes declared through struct tags on all registered models.
+//
+// See Model.EnsureIndexes
+func (z *Sleep) EnsureIndexes() error {
+	for _, model := range z.models {
+		err := model.EnsureIndexes()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSONSchema returns a JSON Schema describing the documents of the model, in the dialect MongoDB uses for
+// $jsonSchema validators: types are given with `bsonType`, so it can be installed as is with SyncValidator.
+// The result can also be written out with encoding/json for services written in other languages.
+//
+// Fields are required unless they are tagged with `bson:",omitempty"` or have a `default` tag, since Sleep
+// always writes them. Pointers and interfaces may hold null and are not required, so that Document.Restore can
+// remove the soft delete marker, and neither are references with the nullify rule, which removes them. Fields tagged with `enum:"a,b,c"` may only hold
+// the listed values, and references are described with the name of the model they point to.
+//
+// The fields of structs tagged with `bson:",inline"` are described as fields of the document that holds them.
+//
+// For models registered with RegisterInterface the result accepts a document of any of the schemas sharing the collection.
+func (m *Model) JSONSchema() bson.M {
+	if m.iface != nil {
+		return m.collectionSchema()
+	}
+	return m.documentSchema()
+}
+
+// collectionSchema describes every document of the model's collection, trying each schema registered on it
+func (m *Model) collectionSchema() bson.M {
+	schemas := []interface{}{}
+	for _, model := range m.z.Models() {
+		if model.iface == nil && model.C.FullName == m.C.FullName {
+			schemas = append(schemas, model.documentSchema())
+		}
+	}
+	if len(schemas) == 1 {
+		return schemas[0].(bson.M)
+	}
+	//a document may match several of the schemas, e.g. when they only differ in optional fields
+	return bson.M{"anyOf": schemas}
+}
+
+func (m *Model) documentSchema() bson.M {
+	schema := objectSchema(m.schema.Fields)
+	schema["title"] = m.name
+	if m.discriminator != nil {
+		schema["properties"].(bson.M)[m.discriminator.key] = bson.M{"enum": []interface{}{m.discriminator.value}}
+	}
+	return schema
+}
+
+func objectSchema(fields []Field) bson.M {
+	properties := bson.M{}
+	required := []string{}
+	for _, field := range fields {
+		properties[field.Key] = fieldSchema(field)
+		if isRequired(field) {
+			required = append(required, field.Key)
+		}
+	}
+	schema := bson.M{"bsonType": "object", "properties": properties}
+	if len(required) != 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func isRequired(field Field) bool {
+	if field.Key == "_id" {
+		return true
+	}
+	switch {
+	case field.Type.Kind() == reflect.Ptr, field.Type.Kind() == reflect.Interface:
+		return false
+	case field.OnDelete == onDeleteNullify:
+		return false
+	}
+	return !strings.Contains(field.Tag.Get("bson"), ",omitempty") && field.Tag.Get("default") == ""
+}
+
+func fieldSchema(field Field) bson.M {
+	var schema bson.M
+	if field.Fields != nil {
+		schema = objectSchema(field.Fields)
+	} else {
+		schema = typeSchema(field.Type)
+	}
+	if field.Ref != "" {
+		schema["description"] = "references " + field.Ref
+	}
+	if field.Enum != nil {
+		target, enum := schema, field.Enum
+		if items, ok := schema["items"].(bson.M); ok {
+			target = items
+		}
+		if field.Type.Kind() == reflect.Ptr {
+			enum = append(enum, nil)
+		}
+		target["enum"] = enum
+	}
+	return schema
+}
+
+var (
+	getterType = reflect.TypeOf((*bson.Getter)(nil)).Elem()
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// typeSchema describes how values of a Go type are stored
+func typeSchema(typ reflect.Type) bson.M {
+	//types that marshal themselves can be stored as anything
+	if typ.Implements(getterType) || reflect.PtrTo(typ).Implements(getterType) {
+		return bson.M{}
+	}
+	switch typ {
+	case timeType:
+		return bson.M{"bsonType": "date"}
+	case objectIdType:
+		return bson.M{"bsonType": "objectId"}
+	case reflect.TypeOf(bson.Binary{}):
+		return bson.M{"bsonType": "binData"}
+	case reflect.TypeOf(bson.D{}), reflect.TypeOf(bson.RawD{}):
+		return bson.M{"bsonType": "object"}
+	case reflect.TypeOf(bson.Raw{}):
+		return bson.M{}
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		schema := typeSchema(typ.Elem())
+		if kind, ok := schema["bsonType"]; ok {
+			schema["bsonType"] = withNull(kind)
+		}
+		return schema
+	case reflect.Interface:
+		return bson.M{}
+	case reflect.String:
+		return bson.M{"bsonType": "string"}
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return bson.M{"bsonType": []string{"int", "long"}}
+	case reflect.Float32, reflect.Float64:
+		return bson.M{"bsonType": []string{"double", "int", "long"}}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return bson.M{"bsonType": "binData"}
+		}
+		return bson.M{"bsonType": "array", "items": typeSchema(typ.Elem())}
+	case reflect.Map:
+		schema := bson.M{"bsonType": "object"}
+		if typ.Elem().Kind() != reflect.Interface {
+			schema["additionalProperties"] = typeSchema(typ.Elem())
+		}
+		return schema
+	case reflect.Struct:
+		return objectSchema(schemaFields(typ, nil, "", ""))
+	}
+	return bson.M{}
+}
+
+func withNull(kind interface{}) []string {
+	if kinds, ok := kind.([]string); ok {
+		return append(append([]string{}, kinds...), "null")
+	}
+	return []string{kind.(string), "null"}
+}
+
+// SyncValidator installs the model's JSON Schema as the $jsonSchema validator of its collection, creating the
+// collection if it does not exist yet. MongoDB then rejects inserts and updates of documents that do not match
+// the schema, whichever client sends them. When several schemas share the collection, the validator accepts
+// documents of any of them.
+//
+// Further reading: https://docs.mongodb.com/manual/core/schema-validation/
+func (m *Model) SyncValidator() error {
+	validator := bson.M{"$jsonSchema": m.collectionSchema()}
+	names, err := m.C.Database.CollectionNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == m.C.Name {
+			return m.C.Database.Run(bson.D{{Name: "collMod", Value: m.C.Name}, {Name: "validator", Value: validator}}, nil)
+		}
+	}
+	return m.C.Database.Run(bson.D{{Name: "create", Value: m.C.Name}, {Name: "validator", Value: validator}}, nil)
+}
+
+// SyncValidators installs the validators of all registered models.
+//
+// See Model.SyncValidator
+func (z *Sleep) SyncValidators() error {
+	synced := make(map[string]bool)
+	for _, model := range z.Models() {
+		if synced[model.C.FullName] {
+			continue
+		}
+		err := model.SyncValidator()
+		if err != nil {
+			return err
+		}
+		synced[model.C.FullName] = true
+	}
+	return nil
+}
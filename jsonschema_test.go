@@ -0,0 +1,114 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testAudit struct {
+	CreatedBy string `bson:"createdBy"`
+	Note      string `bson:"note,omitempty"`
+}
+
+type testAudited struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Status   string        `bson:"status" enum:"draft,published"`
+	Parent   *int          `bson:"parent"`
+	Audit    testAudit     `bson:",inline"`
+	Extra    bson.M        `bson:",inline"`
+}
+
+func TestJSONSchema(t *testing.T) {
+	z := offlineSleep()
+	schema := z.Register(testAudited{}, "audited").JSONSchema()
+	want := bson.M{
+		"bsonType": "object",
+		"title":    "testAudited",
+		"properties": bson.M{
+			"_id":       bson.M{"bsonType": "objectId"},
+			"status":    bson.M{"bsonType": "string", "enum": []interface{}{"draft", "published"}},
+			"parent":    bson.M{"bsonType": []string{"int", "long", "null"}},
+			"createdBy": bson.M{"bsonType": "string"},
+			"note":      bson.M{"bsonType": "string"},
+		},
+		"required": []string{"_id", "status", "createdBy"},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Fatalf("got %#v", schema)
+	}
+}
+
+func TestInlineSchemaFields(t *testing.T) {
+	z := offlineSleep()
+	schema := z.Register(testAudited{}, "audited").Schema()
+	field, ok := schema.Field("Audit.CreatedBy")
+	if !ok || field.KeyPath != "createdBy" {
+		t.Fatalf("got %+v", field)
+	}
+	if _, ok := schema.Field("Audit"); ok {
+		t.Error("the inlined struct is listed as a field")
+	}
+}
+
+func TestCollectionJSONSchema(t *testing.T) {
+	z := offlineSleep()
+	Events, Clicks, Purchases := registerEvents(z)
+	schema := Events.JSONSchema()
+	schemas, ok := schema["anyOf"].([]interface{})
+	if !ok || len(schemas) != 2 {
+		t.Fatalf("got %#v", schema)
+	}
+	for _, model := range []*Model{Clicks, Purchases} {
+		found := false
+		for _, s := range schemas {
+			found = found || reflect.DeepEqual(s, model.JSONSchema())
+		}
+		if !found {
+			t.Errorf("the schema of %s is missing", model.name)
+		}
+	}
+	kind := Clicks.JSONSchema()["properties"].(bson.M)["kind"]
+	if !reflect.DeepEqual(kind, bson.M{"enum": []interface{}{"click"}}) {
+		t.Errorf("got discriminator schema %#v", kind)
+	}
+}
+
+func TestValidatorAllowsClearedFields(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Users := z.Register(testUser{}, "users")
+	Likes := z.Register(testLike{}, "likes")
+	if err := z.SyncValidators(); err != nil {
+		t.Fatal(err)
+	}
+	user := &testUser{Name: "fan"}
+	Users.CreateDoc(user)
+	like := &testLike{User: user.Id}
+	Likes.CreateDoc(like)
+	for _, doc := range []interface{ Save() error }{user, like} {
+		if err := doc.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//restoring removes the soft delete marker
+	if err := user.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	//removing the user for good removes the reference
+	if err := Users.ForceRemoveId(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	stored := &testLike{}
+	if err := Likes.FindId(like.Id).Exec(stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.User != "" {
+		t.Fatalf("the reference was not nullified: %v", stored.User)
+	}
+}
@@ -0,0 +1,276 @@
+// Package migrations runs versioned schema migrations against a Sleep database.
+//
+// Migrations are registered in ascending version order, typically from the init functions of one file per migration:
+//
+//	// migrations/20240115093000_add_user_email_index.go
+//	package migrations
+//
+//	import (
+//		"github.com/mansoor-s/Sleep"
+//		"github.com/mansoor-s/Sleep/migrations"
+//		"gopkg.in/mgo.v2"
+//	)
+//
+//	func init() {
+//		migrations.Register(20240115093000, "add user email index",
+//			func(z *Sleep.Sleep) error {
+//				return z.Model("User").EnsureIndex(mgo.Index{Key: []string{"email"}, Unique: true})
+//			},
+//			func(z *Sleep.Sleep) error {
+//				return z.Model("User").DropIndex("email")
+//			})
+//	}
+//
+// Applied migrations are recorded in a collection, and a lock document keeps two processes from migrating at the same time.
+// Run them with a Migrator, or from the command line through the `migrate` command of the cli package.
+package migrations
+
+import (
+	"errors"
+	"github.com/mansoor-s/Sleep"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the migration lock
+var ErrLocked = errors.New("migrations: another migration is in progress")
+
+// ErrNoDown is returned when reverting a migration that was registered without a down function
+var ErrNoDown = errors.New("migrations: migration can not be reverted")
+
+// Migration is a single versioned change to the database
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(z *Sleep.Sleep) error
+	Down    func(z *Sleep.Sleep) error
+}
+
+// Status describes a registered migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type record struct {
+	Version   int64     `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+type lock struct {
+	Id        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+var registered []Migration
+
+// Register adds a migration to the set run by migrators created with New. Versions must be unique;
+// a common choice is the time the migration was written, e.g. 20240115093000. Down may be nil.
+func Register(version int64, name string, up, down func(z *Sleep.Sleep) error) {
+	for _, m := range registered {
+		if m.Version == version {
+			panic("migrations: version " + strconv.FormatInt(version, 10) + " is registered twice")
+		}
+	}
+	registered = append(registered, Migration{Version: version, Name: name, Up: up, Down: down})
+	sort.Sort(byVersion(registered))
+}
+
+type byVersion []Migration
+
+func (s byVersion) Len() int           { return len(s) }
+func (s byVersion) Less(i, j int) bool { return s[i].Version < s[j].Version }
+func (s byVersion) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Migrator applies and reverts the registered migrations on a database
+type Migrator struct {
+	z          *Sleep.Sleep
+	migrations []Migration
+	records    *mgo.Collection
+	locks      *mgo.Collection
+	owner      string
+	// LockTimeout is how long the lock is held before another process may take it over,
+	// in case the process holding it died. It should be longer than the slowest migration. Defaults to 1 hour.
+	LockTimeout time.Duration
+}
+
+// New returns a Migrator for the registered migrations. Applied migrations are recorded in the "migrations" collection
+func New(z *Sleep.Sleep) *Migrator {
+	return NewWithCollection(z, "migrations")
+}
+
+// NewWithCollection is the same as New, but records the applied migrations in the named collection.
+// The lock document is kept in a collection of the same name with the suffix "_lock".
+func NewWithCollection(z *Sleep.Sleep, collection string) *Migrator {
+	host, _ := os.Hostname()
+	return &Migrator{z: z, migrations: registered,
+		records:     z.Db.C(collection),
+		locks:       z.Db.C(collection + "_lock"),
+		owner:       host + ":" + strconv.Itoa(os.Getpid()) + ":" + bson.NewObjectId().Hex(),
+		LockTimeout: time.Hour}
+}
+
+// Status lists every registered migration, in version order, and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		rec, ok := applied[migration.Version]
+		status[i] = Status{Migration: migration, Applied: ok, AppliedAt: rec.AppliedAt}
+	}
+	return status, nil
+}
+
+// Version returns the version of the latest applied migration, or 0 if none was applied
+func (m *Migrator) Version() (int64, error) {
+	rec := record{}
+	err := m.records.Find(nil).Sort("-_id").One(&rec)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return rec.Version, err
+}
+
+// Up applies every pending migration in version order and returns the migrations applied.
+// It stops at the first migration that fails.
+func (m *Migrator) Up() ([]Migration, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the latest applied migration and returns it. It returns nil if no migration was applied.
+func (m *Migrator) Down() (*Migration, error) {
+	err := m.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer m.release()
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok {
+			return &migration, m.down(migration)
+		}
+	}
+	return nil, nil
+}
+
+// To applies or reverts migrations until exactly the migrations up to and including the given version are applied.
+// Pass 0 to revert every migration. It returns the migrations applied or reverted, in the order they were run.
+func (m *Migrator) To(version int64) ([]Migration, error) {
+	err := m.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer m.release()
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	done := []Migration{}
+	//revert newer migrations first, newest first
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			err = m.down(migration)
+			if err != nil {
+				return done, err
+			}
+			done = append(done, migration)
+		}
+	}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			err = m.up(migration)
+			if err != nil {
+				return done, err
+			}
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+func (m *Migrator) up(migration Migration) error {
+	err := migration.Up(m.z)
+	if err != nil {
+		return &Error{migration, "up", err}
+	}
+	return m.records.Insert(record{migration.Version, migration.Name, time.Now()})
+}
+
+func (m *Migrator) down(migration Migration) error {
+	if migration.Down == nil {
+		return &Error{migration, "down", ErrNoDown}
+	}
+	err := migration.Down(m.z)
+	if err != nil {
+		return &Error{migration, "down", err}
+	}
+	return m.records.RemoveId(migration.Version)
+}
+
+func (m *Migrator) applied() (map[int64]record, error) {
+	records := []record{}
+	err := m.records.Find(nil).All(&records)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+// acquire takes the migration lock, taking over an expired lock if needed
+func (m *Migrator) acquire() error {
+	now := time.Now()
+	err := m.locks.Insert(lock{"lock", m.owner, now.Add(m.LockTimeout)})
+	if err == nil {
+		return nil
+	}
+	if !mgo.IsDup(err) {
+		return err
+	}
+	err = m.locks.Update(bson.M{"_id": "lock", "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": m.owner, "expiresAt": now.Add(m.LockTimeout)}})
+	if err == mgo.ErrNotFound {
+		return ErrLocked
+	}
+	return err
+}
+
+func (m *Migrator) release() {
+	m.locks.Remove(bson.M{"_id": "lock", "owner": m.owner})
+}
+
+// Error is returned when a migration fails
+type Error struct {
+	Migration Migration
+	// Direction is "up" or "down"
+	Direction string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return "migrations: " + e.Direction + " " + strconv.FormatInt(e.Migration.Version, 10) +
+		" (" + e.Migration.Name + ") failed: " + e.Err.Error()
+}
@@ -0,0 +1,217 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mansoor-s/Sleep"
+	"gopkg.in/mgo.v2"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSleep(t testing.TB) (*Sleep.Sleep, func()) {
+	url := os.Getenv("SLEEP_TEST_MONGO")
+	if url == "" {
+		t.Skip("SLEEP_TEST_MONGO is not set")
+	}
+	session, err := mgo.DialWithTimeout(url, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("sleep_test_%d", time.Now().UnixNano())
+	return Sleep.New(session, name), func() {
+		session.DB(name).DropDatabase()
+		session.Close()
+	}
+}
+
+// testMigrations returns migrations 1 to n that append "up n" and "down n" to the log,
+// failing in the directions given by fail
+func testMigrations(n int64, log *[]string, fail map[string]bool) []Migration {
+	migrations := []Migration{}
+	for v := int64(1); v <= n; v++ {
+		v := v
+		step := func(direction string) func(z *Sleep.Sleep) error {
+			return func(z *Sleep.Sleep) error {
+				name := fmt.Sprintf("%s %d", direction, v)
+				if fail[name] {
+					return errors.New("failed")
+				}
+				*log = append(*log, name)
+				return nil
+			}
+		}
+		migrations = append(migrations, Migration{Version: v, Name: fmt.Sprint("migration ", v), Up: step("up"), Down: step("down")})
+	}
+	return migrations
+}
+
+func versions(migrations []Migration) []int64 {
+	versions := []int64{}
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+func TestTo(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	log := []string{}
+	m := New(z)
+	m.migrations = testMigrations(3, &log, nil)
+
+	tests := []struct {
+		to   int64
+		done []int64
+	}{
+		{2, []int64{1, 2}},
+		{2, []int64{}},
+		{3, []int64{3}},
+		{1, []int64{3, 2}},
+		{0, []int64{1}},
+	}
+	for _, test := range tests {
+		migrated, err := m.To(test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := versions(migrated); !reflect.DeepEqual(got, test.done) {
+			t.Errorf("to %d: ran %v, want %v", test.to, got, test.done)
+		}
+		if version, err := m.Version(); err != nil || version != test.to {
+			t.Errorf("to %d: at version %d (%v)", test.to, version, err)
+		}
+	}
+	want := []string{"up 1", "up 2", "up 3", "down 3", "down 2", "down 1"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got log %v", log)
+	}
+}
+
+func TestToStopsOnFailure(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	log := []string{}
+	fail := map[string]bool{"up 2": true}
+	m := New(z)
+	m.migrations = testMigrations(3, &log, fail)
+
+	migrated, err := m.Up()
+	if e, ok := err.(*Error); !ok || e.Direction != "up" || e.Migration.Version != 2 {
+		t.Fatalf("got error %v", err)
+	}
+	if got := versions(migrated); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("ran %v", got)
+	}
+	if version, _ := m.Version(); version != 1 {
+		t.Errorf("at version %d after a failed up", version)
+	}
+
+	delete(fail, "up 2")
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	fail["down 2"] = true
+	migrated, err = m.To(0)
+	if e, ok := err.(*Error); !ok || e.Direction != "down" || e.Migration.Version != 2 {
+		t.Fatalf("got error %v", err)
+	}
+	if got := versions(migrated); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("reverted %v", got)
+	}
+	if version, _ := m.Version(); version != 2 {
+		t.Errorf("at version %d after a failed down", version)
+	}
+	want := []string{"up 1", "up 2", "up 3", "down 3"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got log %v", log)
+	}
+}
+
+func TestDown(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	log := []string{}
+	m := New(z)
+	m.migrations = testMigrations(2, &log, nil)
+
+	if migration, err := m.Down(); migration != nil || err != nil {
+		t.Fatalf("reverted %v (%v) with nothing applied", migration, err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int64{2, 1} {
+		migration, err := m.Down()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration == nil || migration.Version != want {
+			t.Fatalf("reverted %v, want %d", migration, want)
+		}
+	}
+	if version, _ := m.Version(); version != 0 {
+		t.Errorf("at version %d", version)
+	}
+
+	m.migrations[0].Down = nil
+	if _, err := m.To(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(); !isNoDown(err) {
+		t.Errorf("got error %v reverting a migration without a down function", err)
+	}
+}
+
+func isNoDown(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Err == ErrNoDown
+}
+
+func TestLock(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	first, second, third := New(z), New(z), New(z)
+	log := []string{}
+	second.migrations = testMigrations(1, &log, nil)
+
+	if err := first.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.acquire(); err != ErrLocked {
+		t.Fatalf("got %v taking a held lock", err)
+	}
+	if _, err := second.Up(); err != ErrLocked {
+		t.Fatalf("got %v migrating while the lock is held", err)
+	}
+	if len(log) != 0 {
+		t.Fatalf("ran %v while the lock is held", log)
+	}
+	//only the owner releases the lock
+	second.release()
+	if err := second.acquire(); err != ErrLocked {
+		t.Fatalf("got %v after another owner released the lock", err)
+	}
+	first.release()
+
+	//an expired lock is taken over, and the process that held it can no longer release it
+	first.LockTimeout = -time.Minute
+	if err := first.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.acquire(); err != nil {
+		t.Fatalf("got %v taking over an expired lock", err)
+	}
+	first.release()
+	if err := third.acquire(); err != ErrLocked {
+		t.Fatalf("got %v taking a lock that was taken over", err)
+	}
+	second.release()
+	if err := third.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	third.release()
+}
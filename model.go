@@ -1,8 +1,10 @@
 package Sleep
 
 import (
-	"labix.org/v2/mgo"
-	"labix.org/v2/mgo/bson"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"time"
 )
 
 // Model struct represents a collection in MongoDB.
@@ -11,13 +13,35 @@ import (
 type Model struct {
 	*mgo.Collection
 	//C is the underlying mgo.collection value for this model.
-	//Refer to http://godoc.org/labix.org/v2/mgo#Collection for full usage information
-	C *mgo.Collection
-	z *Sleep
+	//Refer to http://godoc.org/gopkg.in/mgo.v2#Collection for full usage information
+	C       *mgo.Collection
+	z       *Sleep
+	name    string
+	scopes  map[string]func(*Query) *Query
+	params  paramFields
+	indexes []mgo.Index
+	//softDelete is nil unless the schema opted into soft deletion
+	softDelete *softDelete
+	refs       []reference
+	defaults   []fieldDefault
+	//idType is the type of the schema's Id field
+	idType      reflect.Type
+	idGenerator func() interface{}
+	sequences   []sequenceField
+	version     *schemaVersion
+	//discriminator is set for schemas that share their collection with other schemas
+	discriminator *discriminator
+	//iface is set for models registered with RegisterInterface
+	iface reflect.Type
+	//schema describes the model. See Model.Schema
+	schema *Schema
+	//info is the cached reflection metadata of the schema type
+	info *typeInfo
 }
 
 func newModel(collection *mgo.Collection, z *Sleep) *Model {
-	model := &Model{collection, collection, z}
+	model := &Model{Collection: collection, C: collection, z: z,
+		scopes: make(map[string]func(*Query) *Query)}
 	return model
 }
 
@@ -39,44 +63,86 @@ func (m *Model) CreateDoc(i interface{}) {
 // The map may be a generic one using interface{} for its key and/or values, such as bson.M, or it may be a properly typed map.
 // Providing nil as the document is equivalent to providing an empty document such as bson.M{}".
 //
-// Further reading: http://godoc.org/labix.org/v2/mgo#Collection.Find
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Collection.Find
 func (m *Model) Find(query interface{}) *Query {
-	return &Query{query: query, z: m.z,
+	return &Query{query: query, z: m.z, model: m,
 		populate:  make(map[string]*Query),
 		populated: make(map[string]interface{}), c: m.C}
 }
 
+// Scope registers a reusable query fragment under the given name.
+// The function receives a copy of the query it is applied to and returns the modified query.
+// Apply scopes using Query.Scope.
+//
+// Example:
+//
+//	User.Scope("active", func(q *Sleep.Query) *Sleep.Query {
+//		return q.Where(bson.M{"active": true})
+//	})
+//	User.Scope("newest", func(q *Sleep.Query) *Sleep.Query {
+//		return q.Sort("-created")
+//	})
+//
+//	User.Find(bson.M{"age": 30}).Scope("active", "newest").Exec(&users)
+func (m *Model) Scope(name string, scope func(*Query) *Query) {
+	m.scopes[name] = scope
+}
+
 // FindId is a convenience function equivalent to:
 //
 //     query := myModel.Find(bson.M{"_id": id})
 //
 // Unlike the Mgo.Collection.FindId function, this function will accept Id both in hex representation as a string or a bson.ObjectId.
+// Models with other Id types take the Id as is.
 //
 // FindId will return a chainable *Query value
 func (m *Model) FindId(id interface{}) *Query {
-	return m.Find(bson.M{"_id": getObjectId(id)})
+	return m.Find(bson.M{"_id": m.id(id)})
 }
 
 // RemoveId removes a document from the collection based on its _id field.
 // Same as mgo.Collection.RemoveId, except that it accepts the Id as a string or bson.ObjectId
 //
-// See http://godoc.org/labix.org/v2/mgo#Collection.RemoveId
+// If the model uses soft deletion the document is only marked as deleted. Use ForceRemoveId to remove it for good.
+// The `ondelete` rules of references to the document apply as with Document.Remove, except that no hooks are called.
+//
+// See http://godoc.org/gopkg.in/mgo.v2#Collection.RemoveId
 func (m *Model) RemoveId(id interface{}) error {
-	return m.C.RemoveId(getObjectId(id))
+	op := &Operation{Op: OpRemove, Model: m, Id: m.id(id)}
+	return m.z.run(op, func() error {
+		if m.softDelete == nil {
+			return m.forceRemove(op.Id)
+		}
+		err := m.checkRestrict(op.Id, make(map[refKey]bool))
+		if err != nil {
+			return err
+		}
+		return m.C.UpdateId(op.Id, M{"$set": M{m.softDelete.name: time.Now()}})
+	})
 }
 
 // UpdateId updates a document in the collection based on its _id field.
 // Same as mgo.Collection.UpdateId, except that it accepts the Id as a string or bson.ObjectId
 //
-// See http://godoc.org/labix.org/v2/mgo#Collection.UpdateId
+// See http://godoc.org/gopkg.in/mgo.v2#Collection.UpdateId
 func (m *Model) UpdateId(id interface{}, change interface{}) error {
-	return m.C.UpdateId(getObjectId(id), change)
+	op := &Operation{Op: OpUpdate, Model: m, Id: m.id(id), Change: change}
+	return m.z.run(op, func() error {
+		return m.C.UpdateId(op.Id, op.Change)
+	})
 }
 
 // UpsertId updates or inserts a document in the collection based on its _id field.
 // Same as mgo.Collection.UpsertId, except that it accepts the Id as a string or bson.ObjectId
 //
-// See http://godoc.org/labix.org/v2/mgo#Collection.UpsertId
+// See http://godoc.org/gopkg.in/mgo.v2#Collection.UpsertId
 func (m *Model) UpsertId(id interface{}, change interface{}) (*mgo.ChangeInfo, error) {
-	return m.C.UpsertId(getObjectId(id), change)
+	op := &Operation{Op: OpUpdate, Model: m, Id: m.id(id), Change: change}
+	var info *mgo.ChangeInfo
+	err := m.z.run(op, func() error {
+		var err error
+		info, err = m.C.UpsertId(op.Id, op.Change)
+		return err
+	})
+	return info, err
 }
@@ -0,0 +1,301 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParamError is returned by Model.ParseURL when a URL parameter is malformed or refers to a field
+// that is not allowed. It is meant to be reported back to the client, e.g. as a "400 Bad Request"
+type ParamError struct {
+	Param  string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return "invalid parameter `" + e.Param + "`: " + e.Reason
+}
+
+// paramField describes what a client may do with a schema field through URL parameters.
+// It is built from the schema's `query` tags when the schema is registered.
+type paramField struct {
+	name     string
+	typ      reflect.Type
+	filter   bool
+	sort     bool
+	selected bool
+}
+
+type paramFields struct {
+	fields   map[string]paramField
+	populate map[string]bool
+}
+
+// operators accepted in filter parameters, e.g. filter[age][gte]=30
+var paramOperators = map[string]string{
+	"eq":     "",
+	"ne":     "$ne",
+	"gt":     "$gt",
+	"gte":    "$gte",
+	"lt":     "$lt",
+	"lte":    "$lte",
+	"in":     "$in",
+	"nin":    "$nin",
+	"exists": "$exists",
+}
+
+var filterParam = regexp.MustCompile(`^filter\[([^\]]+)\](?:\[([^\]]+)\])?$`)
+
+// DefaultPageSize is the number of documents returned per page by queries built with Model.ParseURL
+// when the client does not pass a `limit` parameter
+var DefaultPageSize = 20
+
+// MaxPageSize is the largest `limit` a client may request through Model.ParseURL
+var MaxPageSize = 100
+
+func newParamFields(typ reflect.Type) paramFields {
+	params := paramFields{fields: make(map[string]paramField), populate: make(map[string]bool)}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("query")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		param := paramField{name: bsonName(field), typ: field.Type}
+		for _, flag := range strings.Split(tag, ",") {
+			switch strings.TrimSpace(flag) {
+			case "filter":
+				param.filter = true
+			case "sort":
+				param.sort = true
+			case "select":
+				param.selected = true
+			case "populate":
+				params.populate[field.Name] = true
+			default:
+				panic("Unknown `query` tag option `" + flag + "` on field `" + typ.Name() + "." + field.Name + "`")
+			}
+		}
+		params.fields[param.name] = param
+	}
+	return params
+}
+
+// bsonName returns the key a struct field is stored under, following the rules of the bson package
+func bsonName(field reflect.StructField) string {
+	tag := field.Tag.Get("bson")
+	if tag == "" && !strings.Contains(string(field.Tag), ":") {
+		tag = string(field.Tag)
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// ParseURL builds a query from URL query string parameters. Only fields that were allowed in the schema
+// using the `query` tag can be used. The tag accepts a comma separated list of the following options:
+//
+//		filter   - the field can be used in filter parameters
+//		sort     - the results can be sorted by the field
+//		select   - the field can be requested with the `fields` parameter
+//		populate - the field can be populated with the `populate` parameter
+//
+// Example:
+//
+//	type User struct {
+//		Sleep.Document `bson:"-"`
+//		Id      bson.ObjectId   `bson:"_id"`
+//		Name    string          `query:"filter,sort,select"`
+//		Email   string          `query:"select"`
+//		Age     int             `query:"filter,sort"`
+//		Friends []bson.ObjectId `model:"User" query:"populate"`
+//	}
+//
+//	//GET /users?filter[age][gt]=30&sort=-name&page=2&limit=10&fields=name,email&populate=Friends
+//	query, err := User.ParseURL(r.URL.Query())
+//	if err != nil {
+//		//err is a *Sleep.ParamError, respond with 400
+//	}
+//	err = query.Exec(&users)
+//
+// Filters and sort fields use the field's bson key. Filter values are converted to the field's type.
+// Supported filter operators are eq (the default), ne, gt, gte, lt, lte, in, nin and exists.
+// The in and nin operators take a comma separated list of values. An eq filter combined with other
+// operators on the same field, e.g. filter[age]=30&filter[age][lt]=40, is sent as {"$eq": 30, "$lt": 40}.
+//
+// Parameters other than filter[...], sort, fields, populate, page and limit are ignored.
+// Results are always paged. The page size defaults to DefaultPageSize and can not exceed MaxPageSize. Pages that
+// would skip more than math.MaxInt32 documents are rejected.
+func (m *Model) ParseURL(values url.Values) (*Query, error) {
+	filter := M{}
+	query := m.Find(filter)
+
+	for key, vals := range values {
+		match := filterParam.FindStringSubmatch(key)
+		if match != nil {
+			err := m.params.addFilter(filter, key, match[1], match[2], vals[0])
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		switch key {
+		case "sort":
+			fields := strings.Split(vals[0], ",")
+			for _, field := range fields {
+				param, ok := m.params.fields[strings.TrimPrefix(field, "-")]
+				if !ok || !param.sort {
+					return nil, &ParamError{key, "can not sort by `" + field + "`"}
+				}
+			}
+			query = query.Sort(fields...)
+		case "fields":
+			selection := M{}
+			for _, field := range strings.Split(vals[0], ",") {
+				param, ok := m.params.fields[field]
+				if !ok || !param.selected {
+					return nil, &ParamError{key, "can not select `" + field + "`"}
+				}
+				selection[field] = 1
+			}
+			query = query.Select(selection)
+		case "populate":
+			paths := strings.Split(vals[0], ",")
+			for _, path := range paths {
+				if !m.params.populate[path] {
+					return nil, &ParamError{key, "can not populate `" + path + "`"}
+				}
+			}
+			query = query.Populate(paths...)
+		}
+	}
+
+	limit := DefaultPageSize
+	if val := values.Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return nil, &ParamError{"limit", fmt.Sprintf("must be a number between 1 and %d", MaxPageSize)}
+		}
+		limit = n
+	}
+	page := 1
+	if val := values.Get("page"); val != "" {
+		//the server takes the number of documents to skip as a 32 bit integer
+		maxPage := math.MaxInt32/limit + 1
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 || n > maxPage {
+			return nil, &ParamError{"page", fmt.Sprintf("must be a number between 1 and %d", maxPage)}
+		}
+		page = n
+	}
+
+	return query.Skip((page - 1) * limit).Limit(limit), nil
+}
+
+func (p paramFields) addFilter(filter M, key, field, op, value string) error {
+	param, ok := p.fields[field]
+	if !ok || !param.filter {
+		return &ParamError{key, "can not filter by `" + field + "`"}
+	}
+	if op == "" {
+		op = "eq"
+	}
+	operator, ok := paramOperators[op]
+	if !ok {
+		return &ParamError{key, "unknown operator `" + op + "`"}
+	}
+
+	var val interface{}
+	var err error
+	switch op {
+	case "in", "nin":
+		parts := strings.Split(value, ",")
+		vals := make([]interface{}, len(parts))
+		for i, part := range parts {
+			vals[i], err = convertParam(part, param.typ)
+			if err != nil {
+				break
+			}
+		}
+		val = vals
+	case "exists":
+		val, err = strconv.ParseBool(value)
+	default:
+		val, err = convertParam(value, param.typ)
+	}
+	if err != nil {
+		return &ParamError{key, err.Error()}
+	}
+
+	// an equality filter is stored as the plain value, unless the field is also filtered with other
+	// operators. Then it becomes $eq in the same operator document, whatever order the parameters came in
+	ops, isOps := filter[field].(M)
+	current, exists := filter[field]
+	if operator == "" {
+		if !isOps {
+			filter[field] = val
+			return nil
+		}
+		operator = "$eq"
+	} else if !isOps {
+		ops = M{}
+		if exists {
+			ops["$eq"] = current
+		}
+		filter[field] = ops
+	}
+	ops[operator] = val
+	return nil
+}
+
+// convertParam converts a URL parameter to a value of the given field type.
+// Slices are converted to their element type so that array fields can be matched against a single value.
+func convertParam(value string, typ reflect.Type) (interface{}, error) {
+	switch typ {
+	case reflect.TypeOf(bson.ObjectId("")):
+		if !bson.IsObjectIdHex(value) {
+			return nil, fmt.Errorf("`%s` is not a valid ObjectId", value)
+		}
+		return bson.ObjectIdHex(value), nil
+	case reflect.TypeOf(time.Time{}):
+		return time.Parse(time.RFC3339, value)
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return convertParam(value, typ.Elem())
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` is not a valid integer", value)
+		}
+		return n, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` is not a valid integer", value)
+		}
+		return n, nil
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` is not a valid number", value)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("filtering on fields of type %v is not supported", typ)
+}
@@ -0,0 +1,129 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testParams struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId   `bson:"_id"`
+	Name     string          `query:"filter,sort,select"`
+	Email    string          `query:"select"`
+	Age      int             `query:"filter,sort"`
+	Born     time.Time       `bson:"born" query:"filter"`
+	Tags     []string        `query:"filter"`
+	Friends  []bson.ObjectId `model:"testParams" query:"populate"`
+}
+
+func parseURL(t *testing.T, raw string) (*Query, error) {
+	z := offlineSleep()
+	Params := z.Register(testParams{}, "params")
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Params.ParseURL(values)
+}
+
+func TestParseURLFilters(t *testing.T) {
+	born := time.Date(1990, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		raw    string
+		filter M
+	}{
+		{"filter[name]=bob", M{"name": "bob"}},
+		{"filter[age][gte]=30&filter[age][lt]=40", M{"age": M{"$gte": int64(30), "$lt": int64(40)}}},
+		{"filter[age][in]=1,2", M{"age": M{"$in": []interface{}{int64(1), int64(2)}}}},
+		{"filter[name][exists]=true", M{"name": M{"$exists": true}}},
+		{"filter[tags]=go", M{"tags": "go"}},
+		{"filter[born][gt]=1990-01-02T03:04:05Z", M{"born": M{"$gt": born}}},
+	}
+	for _, test := range tests {
+		query, err := parseURL(t, test.raw)
+		if err != nil {
+			t.Errorf("%s: %v", test.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(query.query, test.filter) {
+			t.Errorf("%s: got filter %#v, want %#v", test.raw, query.query, test.filter)
+		}
+	}
+}
+
+func TestParseURLEqWithOperators(t *testing.T) {
+	want := M{"age": M{"$eq": int64(30), "$lt": int64(40), "$ne": int64(35)}}
+	// url.Values is a map, so parse the same parameters repeatedly to exercise both orders
+	for i := 0; i < 50; i++ {
+		query, err := parseURL(t, "filter[age]=30&filter[age][lt]=40&filter[age][ne]=35")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(query.query, want) {
+			t.Fatalf("got filter %#v, want %#v", query.query, want)
+		}
+	}
+}
+
+func TestParseURLPaging(t *testing.T) {
+	query, err := parseURL(t, "page=3&limit=10&sort=-name,age&fields=name,email&populate=Friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.skip != 20 || query.limit != 10 {
+		t.Errorf("got skip %d and limit %d, want 20 and 10", query.skip, query.limit)
+	}
+	if !reflect.DeepEqual(query.sort, []string{"-name", "age"}) {
+		t.Errorf("got sort %v", query.sort)
+	}
+	if !reflect.DeepEqual(query.selection, M{"name": 1, "email": 1}) {
+		t.Errorf("got selection %#v", query.selection)
+	}
+	if _, ok := query.populate["Friends"]; !ok {
+		t.Error("Friends is not populated")
+	}
+
+	query, err = parseURL(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.skip != 0 || query.limit != DefaultPageSize {
+		t.Errorf("got skip %d and limit %d, want 0 and %d", query.skip, query.limit, DefaultPageSize)
+	}
+}
+
+func TestParseURLErrors(t *testing.T) {
+	tests := []struct {
+		raw   string
+		param string
+	}{
+		{"filter[email]=a", "filter[email]"},
+		{"filter[unknown]=a", "filter[unknown]"},
+		{"filter[age][regex]=a", "filter[age][regex]"},
+		{"filter[age]=old", "filter[age]"},
+		{"filter[age][in]=1,x", "filter[age][in]"},
+		{"filter[born]=yesterday", "filter[born]"},
+		{"sort=email", "sort"},
+		{"fields=age", "fields"},
+		{"populate=Name", "populate"},
+		{"limit=0", "limit"},
+		{"limit=1000", "limit"},
+		{"page=-1", "page"},
+		{"page=9223372036854775807&limit=10", "page"},
+		{"page=214748366&limit=10", "page"},
+	}
+	for _, test := range tests {
+		_, err := parseURL(t, test.raw)
+		paramErr, ok := err.(*ParamError)
+		if !ok {
+			t.Errorf("%s: got error %v, want a *ParamError", test.raw, err)
+			continue
+		}
+		if paramErr.Param != test.param {
+			t.Errorf("%s: error is about `%s`, want `%s`", test.raw, paramErr.Param, test.param)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package Sleep
+
+// Op is the kind of operation passed through plugins
+type Op int
+
+const (
+	// OpFind is Query.Exec
+	OpFind Op = iota
+	// OpPopulate is the query of a single populated field, run by Query.Exec or Document.Populate
+	OpPopulate
+	// OpSave is Document.Save
+	OpSave
+	// OpRemove is Document.Remove, Model.RemoveId, Model.ForceRemoveId and every removal of Model.PurgeDeleted
+	OpRemove
+	// OpUpdate is Document.Apply, Document.Restore, Model.UpdateId and Model.UpsertId, the nullify rule of removed
+	// documents and the fixes of Sleep.CheckReferences
+	OpUpdate
+	// OpBulk is Bulk.Run, Model.SaveAll and Model.InsertAll
+	OpBulk
+	// OpInsert is only used for the inserts listed in the Writes of a bulk operation
+	OpInsert
+)
+
+// Operation describes an operation passed through plugins.
+type Operation struct {
+	Op    Op
+	Model *Model
+	// Query is set for find and populate operations. Plugins may replace it, for example with one that has
+	// more conditions, and the operation runs the replacement.
+	Query *Query
+	// Result is the value the query is executed into
+	Result interface{}
+	// Path is the populate path of populate operations
+	Path string
+	// Document is set for Document methods, Doc being the pointer to its schema value
+	Document *Document
+	Doc      interface{}
+	// Id is set for operations on Ids, Change for updates. Updates of all the documents matching a selector,
+	// such as those of the nullify rule, have Selector set instead of Id.
+	Id       interface{}
+	Change   interface{}
+	Selector interface{}
+	// Writes lists the writes of a bulk operation. Plugins may change the documents but not the list itself.
+	// Once the operation has run, Result holds its *BulkResult.
+	Writes []Write
+}
+
+// Write is a single write of a bulk operation. Op is OpInsert, OpUpdate, OpSave for upserts, or OpRemove
+type Write struct {
+	Op  Op
+	Doc interface{}
+}
+
+// Plugin wraps operations on models. It calls next to run the operation, along with the plugins registered
+// after it, and can act before and after it or return an error without calling next to prevent it.
+type Plugin func(op *Operation, next func() error) error
+
+type plugin struct {
+	fn Plugin
+	// models names the models the plugin applies to. Empty means all of them.
+	models map[string]bool
+}
+
+// Use registers a plugin wrapping the find, populate, save, remove, update and bulk operations of the named models,
+// or of all models if none are named. Plugins run in the order they were registered, the first one outermost.
+// Register plugins before using the models, Use is not safe to call concurrently with operations.
+//
+// Example:
+//
+//	//keep every tenant to its own documents
+//	sleep.Use(func(op *Sleep.Operation, next func() error) error {
+//		switch op.Op {
+//		case Sleep.OpFind, Sleep.OpPopulate:
+//			op.Query = op.Query.Where(bson.M{"tenant": currentTenant})
+//		}
+//		return next()
+//	}, "Invoice", "Customer")
+//
+//	//time every operation
+//	sleep.Use(func(op *Sleep.Operation, next func() error) error {
+//		start := time.Now()
+//		err := next()
+//		metrics.Observe(op.Model.Schema().Name, op.Op, time.Since(start))
+//		return err
+//	})
+//
+// The embedded *mgo.Collection methods do not go through plugins. The writes made by the cascade rule of removed
+// documents are the removal of each document, and go through plugins as such.
+func (z *Sleep) Use(fn Plugin, models ...string) {
+	p := plugin{fn: fn, models: make(map[string]bool, len(models))}
+	for _, name := range models {
+		p.models[name] = true
+	}
+	z.plugins = append(z.plugins, p)
+}
+
+// run passes an operation through the plugins that apply to its model, ending with fn
+func (z *Sleep) run(op *Operation, fn func() error) error {
+	if len(z.plugins) == 0 {
+		return fn()
+	}
+	var call func(i int) error
+	call = func(i int) error {
+		for ; i < len(z.plugins); i++ {
+			p := z.plugins[i]
+			if len(p.models) == 0 || (op.Model != nil && p.models[op.Model.name]) {
+				next := i + 1
+				return p.fn(op, func() error { return call(next) })
+			}
+		}
+		return fn()
+	}
+	return call(0)
+}
@@ -0,0 +1,178 @@
+package Sleep
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errBlocked = errors.New("blocked by plugin")
+
+// blockingPlugin records the operations it sees and stops them
+func blockingPlugin(z *Sleep, models ...string) *[]*Operation {
+	ops := &[]*Operation{}
+	z.Use(func(op *Operation, next func() error) error {
+		*ops = append(*ops, op)
+		return errBlocked
+	}, models...)
+	return ops
+}
+
+func TestPluginOrder(t *testing.T) {
+	z := offlineSleep()
+	Items := z.Register(testItem{}, "items")
+	calls := []string{}
+	z.Use(func(op *Operation, next func() error) error {
+		calls = append(calls, "outer")
+		return next()
+	})
+	z.Use(func(op *Operation, next func() error) error {
+		calls = append(calls, "other model")
+		return next()
+	}, "testPerson")
+	z.Use(func(op *Operation, next func() error) error {
+		calls = append(calls, "inner")
+		return next()
+	}, "testItem")
+	err := z.run(&Operation{Op: OpSave, Model: Items}, func() error {
+		calls = append(calls, "operation")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "inner", "operation"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got calls %v", calls)
+	}
+}
+
+func TestBulkPlugin(t *testing.T) {
+	z := offlineSleep()
+	Items := z.Register(testItem{}, "items")
+	ops := blockingPlugin(z)
+	a, b, c := &testItem{Name: "a"}, &testItem{Name: "b"}, &testItem{Name: "c"}
+	for _, item := range []*testItem{a, b, c} {
+		Items.CreateDoc(item)
+	}
+	result, err := Items.Bulk().Insert(a).Upsert(b).Remove(c).Run()
+	if err != errBlocked || result == nil {
+		t.Fatalf("got %v, %v", result, err)
+	}
+	if len(*ops) != 1 {
+		t.Fatalf("got %d operations", len(*ops))
+	}
+	op := (*ops)[0]
+	want := []Write{{Op: OpInsert, Doc: a}, {Op: OpSave, Doc: b}, {Op: OpRemove, Doc: c}}
+	if op.Op != OpBulk || op.Model != Items || !reflect.DeepEqual(op.Writes, want) || op.Result != result {
+		t.Fatalf("got operation %+v", op)
+	}
+
+	_, err = Items.SaveAll([]*testItem{{Name: "d"}})
+	if err != errBlocked || len(*ops) != 2 || (*ops)[1].Writes[0].Op != OpSave {
+		t.Fatalf("SaveAll did not go through the plugin: %v", err)
+	}
+	_, err = Items.InsertAll([]*testItem{{Name: "e"}})
+	if err != errBlocked || len(*ops) != 3 || (*ops)[2].Writes[0].Op != OpInsert {
+		t.Fatalf("InsertAll did not go through the plugin: %v", err)
+	}
+}
+
+type testLike struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	User     bson.ObjectId `model:"testUser" ondelete:"nullify"`
+}
+
+func TestNullifyPlugin(t *testing.T) {
+	z := offlineSleep()
+	Users := z.Register(testUser{}, "users")
+	Likes := z.Register(testLike{}, "likes")
+	ops := blockingPlugin(z, "testLike")
+	id := bson.NewObjectId()
+	err := Users.applyDeleteRules(id)
+	if err != errBlocked || len(*ops) != 1 {
+		t.Fatalf("got %v and %d operations", err, len(*ops))
+	}
+	op := (*ops)[0]
+	if op.Op != OpUpdate || op.Model != Likes || !reflect.DeepEqual(op.Selector, bson.M{"user": id}) ||
+		!reflect.DeepEqual(op.Change, bson.M{"$unset": bson.M{"user": 1}}) {
+		t.Fatalf("got operation %+v", op)
+	}
+}
+
+func TestCheckReferencesPlugin(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	z.Register(testUser{}, "users")
+	Likes := z.Register(testLike{}, "likes")
+	like := &testLike{User: bson.NewObjectId()}
+	Likes.CreateDoc(like)
+	err := like.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := blockingPlugin(z, "testLike")
+	_, err = z.CheckReferences(CheckOptions{Fix: true})
+	if err != errBlocked || len(*ops) != 1 {
+		t.Fatalf("got %v and %d operations", err, len(*ops))
+	}
+	op := (*ops)[0]
+	if op.Op != OpUpdate || op.Id != like.Id {
+		t.Fatalf("got operation %+v", op)
+	}
+}
+
+func TestSoftDeletePlugin(t *testing.T) {
+	z := offlineSleep()
+	Users := z.Register(testUser{}, "users")
+	ops := blockingPlugin(z, "testUser")
+	user := &testUser{}
+	Users.CreateDoc(user)
+	deletedAt := time.Now()
+	user.DeletedAt = &deletedAt
+
+	err := user.Restore()
+	if err != errBlocked || len(*ops) != 1 {
+		t.Fatalf("got %v and %d operations", err, len(*ops))
+	}
+	op := (*ops)[0]
+	if op.Op != OpUpdate || op.Document != &user.Document || op.Doc != user || op.Id != user.Id ||
+		!reflect.DeepEqual(op.Change, M{"$unset": M{"deletedat": 1}}) {
+		t.Fatalf("got operation %+v", op)
+	}
+	if user.DeletedAt == nil {
+		t.Fatal("a blocked Restore cleared the deleted marker")
+	}
+
+	err = Users.ForceRemoveId(user.Id)
+	if err != errBlocked || len(*ops) != 2 {
+		t.Fatalf("got %v and %d operations", err, len(*ops))
+	}
+	if op := (*ops)[1]; op.Op != OpRemove || op.Id != user.Id {
+		t.Fatalf("got operation %+v", op)
+	}
+}
+
+func TestPurgeDeletedPlugin(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Users := z.Register(testUser{}, "users")
+	user := &testUser{Name: "gone"}
+	Users.CreateDoc(user)
+	if err := user.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	ops := blockingPlugin(z, "testUser")
+	n, err := Users.PurgeDeleted(0)
+	if err != errBlocked || n != 0 || len(*ops) != 1 {
+		t.Fatalf("purged %d users: %v, with %d operations", n, err, len(*ops))
+	}
+	if op := (*ops)[0]; op.Op != OpRemove || op.Id != user.Id {
+		t.Fatalf("got operation %+v", op)
+	}
+}
@@ -2,12 +2,17 @@ package Sleep
 
 import (
 	"fmt"
-	"labix.org/v2/mgo"
-	"labix.org/v2/mgo/bson"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	"reflect"
 	"strings"
+	"time"
 )
 
+// Query is a chainable description of a database query.
+//
+// Query values are immutable. Every chainable method returns a modified copy and leaves its receiver untouched,
+// so a base query can safely be shared and extended across handlers and goroutines.
 type Query struct {
 	query         interface{}
 	selection     interface{}
@@ -24,44 +29,62 @@ type Query struct {
 	populateField interface{}
 	isSlice       bool
 	popSchema     string
+	hint          []string
+	maxTime       time.Duration
+	batch         int
+	comment       string
+	mode          mgo.Mode
+	hasMode       bool
+	model         *Model
+	meta          M
+	virtuals      map[string]string
+	near          *geoNear
+	deleted       int
 }
 
 func (q *Query) populateExec(parentStruct interface{}) error {
-	for key, val := range q.populate {
+	for key, sub := range q.populate {
+		//work on a copy so that the same query can be executed again, or concurrently
+		val := sub.Clone()
 		val.parentStruct = parentStruct
+		val.path = key
 		val.findPopulatePath(key)
 		document, ok := q.z.documents[val.popSchema]
 		if !ok {
 			panic("Unable to find `" + val.popSchema + "` schema. Was it registered?")
 		}
+		val.c = document.C
+		val.model = q.z.models[val.popSchema]
+		val.inheritOptions(q)
+
+		ids := referencedIds(reflect.ValueOf(val.populateField))
+		if len(ids) == 0 {
+			continue
+		}
 
 		var schemaStruct interface{}
 		if val.isSlice {
-			ids := val.populateField.([]bson.ObjectId)
-			if len(ids) == 0 {
-				return nil
-			}
-
 			schemaType := reflect.PtrTo(reflect.TypeOf(document.schemaStruct))
 			slicedType := reflect.SliceOf(schemaType)
 			schemaStruct = reflect.New(slicedType).Interface()
-			val.query = M{"_id": M{"$in": ids}}
+			val.query = andFilter(val.query, M{"_id": M{"$in": ids}})
 
 		} else {
-			schemaStruct = &document.schemaStruct
-			id := val.populateField.(bson.ObjectId)
-			val.query = M{"_id": id}
+			schemaStruct = reflect.New(reflect.TypeOf(document.schemaStruct)).Interface()
+			val.query = andFilter(val.query, M{"_id": ids[0]})
 		}
 
-		err := val.Exec(schemaStruct)
+		op := &Operation{Op: OpPopulate, Model: val.model, Query: val, Result: schemaStruct, Path: key}
+		err := q.z.run(op, func() error {
+			return op.Query.exec(schemaStruct)
+		})
 		if err == mgo.ErrNotFound {
 			return nil
 		}
 		if err != nil {
 			return err
 		}
-		parentModel := reflect.ValueOf(val.parentStruct).Elem().FieldByName("Document").Interface().(Document)
-		parentModel.populated[key] = schemaStruct
+		documentOf(val.parentStruct).populated[key] = schemaStruct
 	}
 	return nil
 }
@@ -70,7 +93,7 @@ func (q *Query) populateExec(parentStruct interface{}) error {
 //
 // This function takes a variable number of arguments.
 // Each argument must be the full path to the field to be populated.
-// The field to be populated can be either of type bson.ObjectId or []bson.ObjectId.
+// The field to be populated can be either of type bson.ObjectId or []bson.ObjectId, or of the Id type of the referenced model and a slice of it.
 // The field must also have a tag with the key "model" and a case sensative value with the name of the model.
 //
 // Example:
@@ -98,6 +121,7 @@ func (q *Query) populateExec(parentStruct interface{}) error {
 //	sleep.FindId("...").Populate("Contacts.BusinessPartner", "Contacts.Competitors").Exec(personResult)
 //
 func (q *Query) Populate(fields ...string) *Query {
+	q = q.Clone()
 	for _, elem := range fields {
 		q.populate[elem] = &Query{isPopOp: true,
 			populate:  make(map[string]*Query),
@@ -118,10 +142,11 @@ func (q *Query) Populate(fields ...string) *Query {
 //
 //	popQuery := sleep.Find(bson.M{"age": bson.M{"$gt": 30} }).Limit(10).Sort("name").Populate("Friend")
 //
+// The query passed in is copied, so it can safely be reused afterwards.
 func (q *Query) PopulateQuery(field string, query *Query) *Query {
+	q = q.Clone()
+	query = query.Clone()
 	query.isPopOp = true
-	query.populate = make(map[string]*Query)
-	query.populated = make(map[string]interface{})
 	query.z = q.z
 	query.c = q.c
 	q.populate[field] = query
@@ -129,6 +154,23 @@ func (q *Query) PopulateQuery(field string, query *Query) *Query {
 }
 
 func (q *Query) findPopulatePath(path string) {
+	if accessors, ok := q.parentStruct.(Accessors); ok {
+		if value, model, ok := accessors.SleepRef(path); ok {
+			q.popSchema = model
+			q.populateField = value
+			q.isSlice = isIdSlice(reflect.TypeOf(value))
+			return
+		}
+	}
+	if model := documentOf(q.parentStruct).Model; model != nil && model.info != nil {
+		if ref, ok := model.info.refs[path]; ok {
+			q.popSchema = ref.model
+			q.populateField = reflect.ValueOf(q.parentStruct).Elem().FieldByIndex(ref.index).Interface()
+			q.isSlice = ref.isSlice
+			return
+		}
+	}
+
 	parts := strings.Split(path, ".")
 	resultVal := reflect.ValueOf(q.parentStruct).Elem()
 
@@ -154,7 +196,7 @@ func (q *Query) findPopulatePath(path string) {
 		}
 	}
 
-	if refVal.Kind() == reflect.Slice {
+	if isIdSlice(refVal.Type()) {
 		q.isSlice = true
 	}
 	q.populateField = refVal.Interface()
@@ -210,7 +252,16 @@ func handleSlice(parent reflect.Value, childField string, path string) reflect.V
 //		//Another example showing further filtering
 //		sleep.Find(bson.M{"location:": "Earth"}).Sort("name", "age").Limit(200).Exec(&foo)
 //
+//
+// Exec passes through the plugins registered with Sleep.Use as a find operation.
 func (query *Query) Exec(result interface{}) error {
+	op := &Operation{Op: OpFind, Model: query.model, Query: query, Result: result}
+	return query.z.run(op, func() error {
+		return op.Query.exec(result)
+	})
+}
+
+func (query *Query) exec(result interface{}) error {
 	if reflect.TypeOf(result).Kind() != reflect.Ptr {
 		panic(fmt.Sprintf("Expecting a pointer type but recieved %v. If you are passing in a slice, make sure to pass a pointer to it.", reflect.TypeOf(result)))
 	}
@@ -218,37 +269,39 @@ func (query *Query) Exec(result interface{}) error {
 	var structName string
 	isSlice := false
 	if typ.Kind() == reflect.Slice {
-		structName = typ.Elem().Elem().Name()
 		isSlice = true
+		typ = typ.Elem()
+		if typ.Kind() != reflect.Interface {
+			structName = typ.Elem().Name()
+		}
 	} else {
 		structName = typ.Name()
 	}
 
-	q := query.c.Find(query.query)
-
-	if query.limit != 0 {
-		q = q.Limit(query.limit)
-	}
-
-	if query.skip != 0 {
-		q = q.Skip(query.skip)
-	}
-
-	sortLen := len(query.sort)
-	if sortLen != 0 {
-		for i := 0; i < sortLen; i++ {
-			q = q.Sort(query.sort[i])
-		}
+	c, release := query.collection()
+	defer release()
+	q := query.reader(c)
+	if query.z.devMode {
+		query.warnCollScan()
 	}
 
-	if query.selection != nil {
-		q = q.Select(query.selection)
+	if typ.Kind() == reflect.Interface {
+		return query.execInterface(q, result, typ, isSlice)
 	}
 
-	document := query.z.documents[structName]
+	document := query.z.document(structName)
+	model := query.z.models[structName]
+	//the stored documents are only looked at when there is something to read from them
+	readRaw := len(query.virtuals) != 0 ||
+		(model != nil && (model.version != nil || len(model.defaults) != 0) && query.selection == nil)
 	var err error
 	if isSlice == true {
-		err = q.All(result)
+		var raws []storedDoc
+		if readRaw {
+			raws, err = allRaw(q, model, result, query.selection == nil)
+		} else {
+			err = q.All(result)
+		}
 		if err != nil {
 			if err == mgo.ErrNotFound {
 				return nil
@@ -259,27 +312,33 @@ func (query *Query) Exec(result interface{}) error {
 		val := reflect.ValueOf(result).Elem()
 		elemCount := val.Len()
 		for i := 0; i < elemCount; i++ {
-			documentCpy := query.z.documents[structName]
-			sliceElem := val.Index(i)
-			documentCpy.schema = sliceElem.Interface()
-			documentCpy.Virtual = newVirtual()
-			documentCpy.Model = query.z.models[structName]
-			modelElem := sliceElem.Elem().FieldByName("Document")
-			modelElem.Set(reflect.ValueOf(documentCpy))
+			documentCpy := query.z.document(structName)
+			documentCpy.schema = val.Index(i).Interface()
+			if raws != nil {
+				query.afterLoad(model, &documentCpy, raws[i])
+			}
+			documentCpy.Model = model
+			*documentOf(documentCpy.schema) = documentCpy
 		}
 		return err
 	}
 
-	err = q.One(result)
+	var raw storedDoc
+	if readRaw {
+		raw, err = oneRaw(q, model, result, query.selection == nil)
+	} else {
+		err = q.One(result)
+	}
 	document.schema = result
-	document.Virtual = newVirtual()
-	document.Model = query.z.models[structName]
-	val := reflect.ValueOf(result).Elem()
-	documentVal := val.FieldByName("Document")
-	documentVal.Set(reflect.ValueOf(document))
+	if raw.elems != nil {
+		query.afterLoad(model, &document, raw)
+	}
+	document.Model = model
+	documentVal := documentOf(result)
+	*documentVal = document
 
 	if err == mgo.ErrNotFound {
-		documentVal.FieldByName("Found").SetBool(false)
+		documentVal.Found = false
 		return nil
 	}
 
@@ -295,6 +354,184 @@ func (query *Query) Exec(result interface{}) error {
 	return query.populateExec(result)
 }
 
+// collection returns the collection the query runs against. If a consistency mode was set on the query
+// the collection is bound to a copy of the session. The returned function releases that copy and must be
+// called once the query is done.
+func (query *Query) collection() (*mgo.Collection, func()) {
+	if !query.hasMode {
+		return query.c, func() {}
+	}
+	session := query.c.Database.Session.Copy()
+	session.SetMode(query.mode, true)
+	return query.c.With(session), session.Close
+}
+
+// filter returns the query's filter, including the conditions Sleep adds on behalf of the model
+func (query *Query) filter() interface{} {
+	if query.model == nil {
+		return query.query
+	}
+	filter := query.query
+	if query.model.iface != nil {
+		return andFilter(filter, query.model.interfaceFilter(query.deleted))
+	}
+	if query.model.softDelete != nil {
+		filter = andFilter(filter, query.model.softDelete.filter(query.deleted))
+	}
+	if d := query.model.discriminator; d != nil {
+		filter = andFilter(filter, M{d.key: d.value})
+	}
+	return filter
+}
+
+// reader is what the results of a query are read from. It is an *mgo.Query, or an *mgo.Pipe for queries
+// that run as an aggregation
+type reader interface {
+	One(result interface{}) error
+	All(result interface{}) error
+	Explain(result interface{}) error
+}
+
+// reader returns the reader for the query's results. Near queries run as a $geoNear aggregation,
+// everything else as a plain find
+func (query *Query) reader(c *mgo.Collection) reader {
+	if query.near == nil {
+		return query.mgoQuery(c)
+	}
+	pipe := c.Pipe(query.near.pipeline(query, query.filter()))
+	if query.batch != 0 {
+		pipe = pipe.Batch(query.batch)
+	}
+	return pipe
+}
+
+// mgoQuery builds the underlying *mgo.Query with all of the options set on the query
+func (query *Query) mgoQuery(c *mgo.Collection) *mgo.Query {
+	q := c.Find(query.filter())
+
+	if query.limit != 0 {
+		q = q.Limit(query.limit)
+	}
+
+	if query.skip != 0 {
+		q = q.Skip(query.skip)
+	}
+
+	if len(query.sort) != 0 {
+		q = q.Sort(query.sort...)
+	}
+
+	if len(query.meta) != 0 {
+		q = q.Select(mergeSelection(query.selection, query.meta))
+	} else if query.selection != nil {
+		q = q.Select(query.selection)
+	}
+
+	if len(query.hint) != 0 {
+		q = q.Hint(query.hint...)
+	}
+
+	if query.maxTime != 0 {
+		q = q.SetMaxTime(query.maxTime)
+	}
+
+	if query.batch != 0 {
+		q = q.Batch(query.batch)
+	}
+
+	if query.comment != "" {
+		q = q.Comment(query.comment)
+	}
+	return q
+}
+
+// inheritOptions copies the options of a parent query that also make sense for its populate sub-queries.
+// Options that were explicitly set on the sub-query are kept. The index hint is not inherited since
+// the sub-query usually runs on a different collection.
+func (q *Query) inheritOptions(parent *Query) {
+	if q.maxTime == 0 {
+		q.maxTime = parent.maxTime
+	}
+	if q.batch == 0 {
+		q.batch = parent.batch
+	}
+	if q.comment == "" {
+		q.comment = parent.comment
+	}
+	if !q.hasMode && parent.hasMode {
+		q.mode = parent.mode
+		q.hasMode = true
+	}
+}
+
+// afterLoad fills in the parts of a loaded document that come from its stored elements rather than from decoding it
+func (query *Query) afterLoad(model *Model, document *Document, doc storedDoc) {
+	query.setVirtuals(document.Virtual, doc.elems)
+	document.upgraded = doc.upgraded
+	if query.selection == nil {
+		model.applyMissingDefaults(document.schema, doc.elems)
+	}
+}
+
+// oneRaw runs the query for a single result and also returns the elements of the stored document.
+// Sleep reads them to fill in computed fields (such as text search scores) and to find fields missing from the document.
+// The document is upgraded to the current schema version if upgrade is set.
+func oneRaw(q reader, model *Model, result interface{}, upgrade bool) (storedDoc, error) {
+	raw := bson.Raw{}
+	err := q.One(&raw)
+	if err != nil {
+		return storedDoc{}, err
+	}
+	return model.decodeRaw(raw, result, upgrade)
+}
+
+// allRaw is the same as oneRaw for a pointer to a slice of results
+func allRaw(q reader, model *Model, result interface{}, upgrade bool) ([]storedDoc, error) {
+	raws := []bson.Raw{}
+	err := q.All(&raws)
+	if err != nil {
+		return nil, err
+	}
+	sliceVal := reflect.ValueOf(result).Elem()
+	elemType := sliceVal.Type().Elem().Elem()
+	sliceVal.Set(reflect.MakeSlice(sliceVal.Type(), 0, len(raws)))
+	docs := make([]storedDoc, len(raws))
+	for i, raw := range raws {
+		elem := reflect.New(elemType)
+		docs[i], err = model.decodeRaw(raw, elem.Interface(), upgrade)
+		if err != nil {
+			return nil, err
+		}
+		sliceVal.Set(reflect.Append(sliceVal, elem))
+	}
+	return docs, nil
+}
+
+// setVirtuals stores the values the query asked the server to project under their virtual names.
+// Numbers are stored as float64 values and can be read with Virtual.GetFloat, everything else with Virtual.Get
+func (query *Query) setVirtuals(v *Virtual, doc bson.RawD) {
+	for _, elem := range doc {
+		name, ok := query.virtuals[elem.Name]
+		if !ok {
+			continue
+		}
+		var val interface{}
+		if elem.Value.Unmarshal(&val) != nil {
+			continue
+		}
+		switch n := val.(type) {
+		case float64:
+			v.SetFloat(name, n)
+		case int:
+			v.SetFloat(name, float64(n))
+		case int64:
+			v.SetFloat(name, float64(n))
+		default:
+			v.Set(name, val)
+		}
+	}
+}
+
 // Select enables selecting which fields should be retrieved for the results found.
 // For example, the following query would only retrieve the name field:
 //
@@ -304,6 +541,7 @@ func (query *Query) Exec(result interface{}) error {
 //
 // Note 2**: If only some fields are selected for retrieval and then the Save() is called on the document, the fields not retrieved will be blank and will overwrite the database values with the default value for their respective types.
 func (q *Query) Select(selection interface{}) *Query {
+	q = q.Clone()
 	q.selection = selection
 	return q
 }
@@ -311,12 +549,14 @@ func (q *Query) Select(selection interface{}) *Query {
 // Skip skips over the n initial documents from the query results.
 // Using Skip only makes sense with ordered results and capped collections where documents are naturally ordered by insertion time.
 func (q *Query) Skip(skip int) *Query {
+	q = q.Clone()
 	q.skip = skip
 	return q
 }
 
 // Limit sets the maximum number of document the database should return
 func (q *Query) Limit(lim int) *Query {
+	q = q.Clone()
 	q.limit = lim
 	return q
 }
@@ -330,9 +570,181 @@ func (q *Query) Limit(lim int) *Query {
 //		query2 := sleep.Find(nil).Sort("-age")
 //		query3 := sleep.Find(nil).Sort("$natural")
 //
+// Calling Sort more than once appends to the existing sort fields, so the following are equivalent:
+//
+//		query1 := sleep.Find(nil).Sort("firstname").Sort("-age")
+//		query2 := sleep.Find(nil).Sort("firstname", "-age")
+//
+// Use ClearSort to discard the sort fields set so far.
 //
-// Further reading: http://godoc.org/labix.org/v2/mgo#Query.Sort
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Query.Sort
 func (q *Query) Sort(fields ...string) *Query {
-	q.sort = fields
+	q = q.Clone()
+	q.sort = append(q.sort, fields...)
 	return q
 }
+
+// ClearSort removes all of the sort fields set on the query
+func (q *Query) ClearSort() *Query {
+	q = q.Clone()
+	q.sort = nil
+	return q
+}
+
+// Hint forces the server to use the index with the given key. The key is described the same way
+// as with mgo.Collection.EnsureIndexKey
+//
+//		sleep.Find(bson.M{"firstname": "Joe", "lastname": "Winter"}).Hint("lastname", "firstname").Exec(&result)
+//
+// Unlike the other options, the hint is not passed on to populate sub-queries.
+//
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Query.Hint
+func (q *Query) Hint(indexKey ...string) *Query {
+	q = q.Clone()
+	q.hint = indexKey
+	return q
+}
+
+// MaxTime sets the maximum amount of time the server may spend executing the query before aborting it.
+//
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Query.SetMaxTime
+func (q *Query) MaxTime(d time.Duration) *Query {
+	q = q.Clone()
+	q.maxTime = d
+	return q
+}
+
+// BatchSize sets the number of documents the server returns in each batch of results.
+//
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Query.Batch
+func (q *Query) BatchSize(n int) *Query {
+	q = q.Clone()
+	q.batch = n
+	return q
+}
+
+// Comment attaches a comment to the query. The comment shows up in the server's profiler and logs,
+// which makes it easier to track where a slow query came from.
+//
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Query.Comment
+func (q *Query) Comment(comment string) *Query {
+	q = q.Clone()
+	q.comment = comment
+	return q
+}
+
+// Mode sets the consistency mode (read preference) for this query only. The query runs on a copy of
+// the session with the given mode, leaving the session Sleep was created with untouched.
+//
+//		sleep.Find(bson.M{"age": 40}).Mode(mgo.Monotonic).Exec(&result)
+//
+// Further reading: http://godoc.org/gopkg.in/mgo.v2#Session.SetMode
+func (q *Query) Mode(mode mgo.Mode) *Query {
+	q = q.Clone()
+	q.mode = mode
+	q.hasMode = true
+	return q
+}
+
+// Clone returns a deep copy of the query, including its populate sub-queries.
+// The filter and selection documents themselves are shared as they are never modified by Sleep.
+func (q *Query) Clone() *Query {
+	clone := *q
+	clone.sort = append([]string(nil), q.sort...)
+	clone.hint = append([]string(nil), q.hint...)
+	clone.populated = make(map[string]interface{})
+	clone.meta = make(M, len(q.meta))
+	for key, val := range q.meta {
+		clone.meta[key] = val
+	}
+	clone.virtuals = make(map[string]string, len(q.virtuals))
+	for key, name := range q.virtuals {
+		clone.virtuals[key] = name
+	}
+	clone.populate = make(map[string]*Query, len(q.populate))
+	for path, sub := range q.populate {
+		clone.populate[path] = sub.Clone()
+	}
+	return &clone
+}
+
+// Where merges the given filter with the query's existing filter. Both must match for a document to be returned.
+// The filters are combined using $and, so filters on the same field do not overwrite each other.
+//
+//		base := User.Find(bson.M{"active": true})
+//		tenant := base.Where(bson.M{"tenant": tenantId})
+//		tenant.Where(bson.M{"age": bson.M{"$gt": 30}}).Exec(&users)
+func (q *Query) Where(filter interface{}) *Query {
+	q = q.Clone()
+	q.query = andFilter(q.query, filter)
+	return q
+}
+
+// Scope applies the named scopes, in order, to a copy of the query. The scopes must have been
+// registered on the query's model using Model.Scope. Will panic if a scope can not be found.
+//
+//		User.Find(nil).Scope("active", "adults").Sort("name").Exec(&users)
+func (q *Query) Scope(names ...string) *Query {
+	if q.model == nil {
+		panic("Scopes can only be applied to queries started from a Model")
+	}
+	for _, name := range names {
+		scope, ok := q.model.scopes[name]
+		if !ok {
+			panic("Unable to find scope `" + name + "`. Was it registered?")
+		}
+		q = scope(q.Clone())
+	}
+	return q
+}
+
+// andFilter combines two filter documents with $and. Empty filters are dropped.
+func andFilter(a, b interface{}) interface{} {
+	if isEmptyFilter(a) {
+		return b
+	}
+	if isEmptyFilter(b) {
+		return a
+	}
+	return M{"$and": []interface{}{a, b}}
+}
+
+func isEmptyFilter(filter interface{}) bool {
+	if filter == nil {
+		return true
+	}
+	val := reflect.ValueOf(filter)
+	switch val.Kind() {
+	case reflect.Map, reflect.Slice:
+		return val.Len() == 0
+	case reflect.Ptr:
+		return val.IsNil()
+	}
+	return false
+}
+
+// mergeSelection adds computed fields such as {"$meta": "textScore"} to the user's selection.
+func mergeSelection(selection interface{}, extra M) M {
+	merged := M{}
+	switch sel := selection.(type) {
+	case nil:
+	case M:
+		for key, val := range sel {
+			merged[key] = val
+		}
+	case bson.M:
+		for key, val := range sel {
+			merged[key] = val
+		}
+	default:
+		data, err := bson.Marshal(selection)
+		if err != nil {
+			panic(err)
+		}
+		bson.Unmarshal(data, &merged)
+	}
+	for key, val := range extra {
+		merged[key] = val
+	}
+	return merged
+}
@@ -0,0 +1,86 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// rawElems marshals a document and returns its elements the way they are read from the server
+func rawElems(t *testing.T, doc interface{}) bson.RawD {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elems := bson.RawD{}
+	if err := bson.Unmarshal(data, &elems); err != nil {
+		t.Fatal(err)
+	}
+	return elems
+}
+
+func TestSetVirtuals(t *testing.T) {
+	z := offlineSleep()
+	People := z.Register(testPerson{}, "people")
+	query := People.Find(M{}).Search("bob", "")
+
+	v := newVirtual()
+	query.setVirtuals(v, rawElems(t, bson.M{"name": "bob", scoreKey: 1.5}))
+	if score, ok := v.GetFloat("score"); !ok || score != 1.5 {
+		t.Errorf("got score %v, %v, want 1.5", score, ok)
+	}
+	if _, ok := v.Get("name"); ok {
+		t.Error("stored a field that was not projected as a virtual")
+	}
+
+	v = newVirtual()
+	query.setVirtuals(v, rawElems(t, bson.M{scoreKey: int64(2)}))
+	if score, ok := v.GetFloat("score"); !ok || score != 2 {
+		t.Errorf("integer scores are not stored as floats, got %v, %v", score, ok)
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	z := offlineSleep()
+	People := z.Register(testPerson{}, "people")
+	base := People.Find(nil)
+	query := base.Hint("name").MaxTime(time.Second).BatchSize(50).Comment("report").Mode(mgo.Eventual)
+	if !reflect.DeepEqual(query.hint, []string{"name"}) || query.maxTime != time.Second || query.batch != 50 ||
+		query.comment != "report" || query.mode != mgo.Eventual || !query.hasMode {
+		t.Fatalf("options were not set: %+v", query)
+	}
+	if base.hint != nil || base.maxTime != 0 || base.batch != 0 || base.comment != "" || base.hasMode {
+		t.Error("setting options changed the original query")
+	}
+}
+
+func TestInheritOptions(t *testing.T) {
+	z := offlineSleep()
+	People := z.Register(testPerson{}, "people")
+	parent := People.Find(nil).Hint("name").MaxTime(time.Second).BatchSize(50).Comment("report").Mode(mgo.Eventual)
+
+	sub := People.Find(nil)
+	sub.inheritOptions(parent)
+	if sub.maxTime != time.Second || sub.batch != 50 || sub.comment != "report" || sub.mode != mgo.Eventual || !sub.hasMode {
+		t.Errorf("options were not inherited: %+v", sub)
+	}
+	if sub.hint != nil {
+		t.Error("the index hint was inherited")
+	}
+
+	//options set on the sub-query are kept
+	own := People.Find(nil).MaxTime(time.Minute).BatchSize(10).Comment("friends").Mode(mgo.Strong)
+	own.inheritOptions(parent)
+	if own.maxTime != time.Minute || own.batch != 10 || own.comment != "friends" || own.mode != mgo.Strong {
+		t.Errorf("options of the sub-query were replaced: %+v", own)
+	}
+
+	//a mode is only inherited if the parent set one
+	plain := People.Find(nil)
+	plain.inheritOptions(People.Find(nil))
+	if plain.hasMode {
+		t.Error("a mode was inherited from a parent without one")
+	}
+}
@@ -0,0 +1,230 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hookNames lists the hooks a schema can implement
+var hookNames = []string{"PreSave", "Validate", "PostSave", "PreRemove", "PostRemove", "OnCreate", "OnResult"}
+
+// Schema describes a registered model: its fields, references, indexes and hooks.
+// It is computed once when the model is registered and must not be modified. See Model.Schema
+type Schema struct {
+	// Name is the name the model is registered under, Collection the name of its collection
+	Name       string
+	Collection string
+	// Type is the schema's struct type, or the interface type for models registered with RegisterInterface
+	Type reflect.Type
+	// Fields lists the persisted fields of the schema in declaration order. The fields of structs tagged with
+	// `bson:",inline"` are listed in place of the struct.
+	Fields []Field
+	// Indexes are the indexes declared through struct tags. See Model.EnsureIndexes
+	Indexes []mgo.Index
+	// Hooks lists the hooks in the method set of a pointer to the schema, e.g. "PreSave", including hooks promoted
+	// from embedded structs. The do-nothing stand-ins promoted from Sleep.Document are left out.
+	Hooks []string
+	// DiscriminatorKey and Discriminator are set for schemas that share their collection with other schemas
+	DiscriminatorKey string
+	Discriminator    string
+}
+
+// Field describes a persisted field of a schema
+type Field struct {
+	// Name is the Go name of the field and Path its full Go path, as used with Populate
+	Name string
+	Path string
+	// Key is the key the field is stored under and KeyPath its full dotted bson path
+	Key     string
+	KeyPath string
+	Type    reflect.Type
+	Tag     reflect.StructTag
+	// Ref is the name of the model the field references through the model tag, or empty.
+	// OnDelete is its `ondelete` rule.
+	Ref      string
+	OnDelete string
+	// Enum lists the values allowed by the field's `enum` tag, e.g. `enum:"draft,published"`
+	Enum []interface{}
+	// Fields lists the fields of embedded structs
+	Fields []Field
+}
+
+// HasHook reports whether the schema implements the named hook. See Schema.Hooks
+func (s *Schema) HasHook(name string) bool {
+	for _, hook := range s.Hooks {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Field returns the field with the given Go path, e.g. "Contacts.BusinessPartner"
+func (s *Schema) Field(path string) (Field, bool) {
+	var find func(fields []Field) (Field, bool)
+	find = func(fields []Field) (Field, bool) {
+		for _, field := range fields {
+			if field.Path == path {
+				return field, true
+			}
+			if found, ok := find(field.Fields); ok {
+				return found, true
+			}
+		}
+		return Field{}, false
+	}
+	return find(s.Fields)
+}
+
+// Schema returns the description of the model's schema
+func (m *Model) Schema() *Schema {
+	return m.schema
+}
+
+// Models returns the registered models, sorted by name
+func (z *Sleep) Models() []*Model {
+	models := make([]*Model, 0, len(z.models))
+	for _, model := range z.models {
+		models = append(models, model)
+	}
+	sort.Sort(byName(models))
+	return models
+}
+
+type byName []*Model
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].name < s[j].name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// newSchema describes a registered model. It runs after the rest of the model's metadata was built
+func newSchema(m *Model, typ reflect.Type) *Schema {
+	schema := &Schema{Name: m.name, Collection: m.C.Name, Type: typ, Indexes: m.indexes}
+	if m.discriminator != nil {
+		schema.DiscriminatorKey = m.discriminator.key
+		schema.Discriminator = m.discriminator.value
+	}
+	if typ.Kind() != reflect.Struct {
+		return schema
+	}
+	refs := make(map[string]reference, len(m.refs))
+	for _, ref := range m.refs {
+		refs[ref.field] = ref
+	}
+	schema.Fields = schemaFields(typ, refs, "", "")
+	for _, hook := range hookNames {
+		if implementsHook(typ, hook) {
+			schema.Hooks = append(schema.Hooks, hook)
+		}
+	}
+	return schema
+}
+
+func schemaFields(typ reflect.Type, refs map[string]reference, pathPrefix, keyPrefix string) []Field {
+	fields := []Field{}
+	for i := 0; i < typ.NumField(); i++ {
+		structField := typ.Field(i)
+		key := bsonName(structField)
+		if structField.PkgPath != "" || key == "-" {
+			continue
+		}
+		if isInline(structField) {
+			//the fields of inlined structs are stored in the parent document, inlined maps hold any other keys
+			if structField.Type.Kind() == reflect.Struct {
+				fields = append(fields, schemaFields(structField.Type, refs, pathPrefix+structField.Name+".", keyPrefix)...)
+			}
+			continue
+		}
+		field := Field{Name: structField.Name, Path: pathPrefix + structField.Name,
+			Key: key, KeyPath: keyPrefix + key, Type: structField.Type, Tag: structField.Tag}
+		if ref, ok := refs[field.Path]; ok {
+			field.Ref = ref.model
+			field.OnDelete = ref.onDelete
+		}
+		if enum := structField.Tag.Get("enum"); enum != "" {
+			field.Enum = enumValues(structField, enum)
+		}
+		if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Time{}) {
+			field.Fields = schemaFields(structField.Type, refs, field.Path+".", field.KeyPath+".")
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// isInline reports whether the field is tagged with `bson:",inline"`
+func isInline(field reflect.StructField) bool {
+	for _, flag := range strings.Split(field.Tag.Get("bson"), ",")[1:] {
+		if flag == "inline" {
+			return true
+		}
+	}
+	return false
+}
+
+func enumValues(field reflect.StructField, enum string) []interface{} {
+	values := []interface{}{}
+	for _, value := range strings.Split(enum, ",") {
+		converted, err := convertParam(value, field.Type)
+		if err != nil {
+			panic("Invalid enum value `" + value + "` on field `" + field.Name + "`: " + err.Error())
+		}
+		values = append(values, converted)
+	}
+	return values
+}
+
+// implementsHook reports whether the hook method is in the method set of a pointer to the schema,
+// and is not one of the stand-ins promoted from Sleep.Document
+func implementsHook(typ reflect.Type, name string) bool {
+	owner, _ := methodOwner(typ, name)
+	return owner != nil && owner != reflect.TypeOf(Document{})
+}
+
+// methodOwner returns the type that declares the named method of a pointer to typ, following the promotion rules
+// of embedded fields, and how deep it is embedded. It returns nil if the method is not in the method set.
+func methodOwner(typ reflect.Type, name string) (reflect.Type, int) {
+	if _, ok := reflect.PtrTo(typ).MethodByName(name); !ok {
+		return nil, 0
+	}
+	if declaresMethod(typ, name) || typ.Kind() != reflect.Struct {
+		return typ, 0
+	}
+	var owner reflect.Type
+	depth := 0
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if found, d := methodOwner(fieldType, name); found != nil && (owner == nil || d+1 < depth) {
+			owner, depth = found, d+1
+		}
+	}
+	return owner, depth
+}
+
+// declaresMethod reports whether the named method is declared on typ itself rather than promoted from an
+// embedded field. reflect does not tell them apart, but the compiler generates the wrappers of promoted methods,
+// and of value methods called through a pointer, and the runtime reports them as "<autogenerated>".
+func declaresMethod(typ reflect.Type, name string) bool {
+	for _, t := range []reflect.Type{reflect.PtrTo(typ), typ} {
+		method, ok := t.MethodByName(name)
+		if !ok {
+			continue
+		}
+		pc := method.Func.Pointer()
+		if file, _ := runtime.FuncForPC(pc).FileLine(pc); file != "<autogenerated>" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package Sleep
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testValidated struct{}
+
+func (v *testValidated) Validate() error { return nil }
+
+type testPromoted struct {
+	testValidated
+}
+
+type testValueHook struct{}
+
+func (v testValueHook) PreSave() {}
+
+// testShadowed declares a hook that Sleep.Document also has, and Sleep.Document's Validate shadows the deeper
+// testValidated's
+type testShadowed struct {
+	Document
+	testPromoted
+}
+
+func (s *testShadowed) PreSave() {}
+
+// testHidden embeds testShadowed's PreSave as deep as Sleep.Document's, so neither is promoted
+type testHidden struct {
+	Document
+	testShadowed
+}
+
+func TestSchemaHooks(t *testing.T) {
+	z := offlineSleep()
+	//the stand-ins of Sleep.Document are not hooks of the schema
+	if got := z.Register(testPerson{}, "people").Schema().Hooks; got != nil {
+		t.Errorf("got hooks %v", got)
+	}
+	schema := z.Register(testItem{}, "items").Schema()
+	if got := schema.Hooks; !reflect.DeepEqual(got, []string{"Validate"}) {
+		t.Errorf("got hooks %v", got)
+	}
+	if !schema.HasHook("Validate") || schema.HasHook("PreSave") {
+		t.Error("wrong result from HasHook")
+	}
+	if got := z.Register(testArticle{}, "articles").Schema().Hooks; !reflect.DeepEqual(got, []string{"PreSave"}) {
+		t.Errorf("got promoted hooks %v", got)
+	}
+
+	tests := []struct {
+		schema interface{}
+		hooks  []string
+	}{
+		{testValidated{}, []string{"Validate"}},
+		{testPromoted{}, []string{"Validate"}},
+		{testValueHook{}, []string{"PreSave"}},
+		{testShadowed{}, []string{"PreSave"}},
+		{testHidden{}, nil},
+	}
+	for _, test := range tests {
+		var hooks []string
+		for _, hook := range hookNames {
+			if implementsHook(reflect.TypeOf(test.schema), hook) {
+				hooks = append(hooks, hook)
+			}
+		}
+		if !reflect.DeepEqual(hooks, test.hooks) {
+			t.Errorf("%T: got hooks %v, want %v", test.schema, hooks, test.hooks)
+		}
+	}
+}
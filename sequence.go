@@ -0,0 +1,147 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+)
+
+// SequenceOptions configures a named sequence. See Sleep.Sequence
+type SequenceOptions struct {
+	// Start is the first value handed out. Defaults to 1 when nil. Use Int64 to set it, e.g. Start: Sleep.Int64(0).
+	// It is only applied when the sequence's counter is created, that is when the first value is handed out.
+	Start *int64
+	// Step is added to the previous value to get the next one. Defaults to 1
+	Step int64
+}
+
+// Int64 returns a pointer to n, for setting SequenceOptions.Start
+func Int64(n int64) *int64 {
+	return &n
+}
+
+// sequenceField is a schema field tagged with `sequence`
+type sequenceField struct {
+	field string
+	index []int
+	name  string
+	// per is the index of the field whose value partitions the sequence, or nil
+	per []int
+}
+
+// newSequences collects the fields of a schema tagged with `sequence:"name"` or `sequence:"name,per=Field"`
+func newSequences(typ reflect.Type) []sequenceField {
+	sequences := []sequenceField{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("sequence")
+		if tag == "" {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		default:
+			panic("Sequence field `" + typ.Name() + "." + field.Name + "` must be an integer")
+		}
+		parts := strings.Split(tag, ",")
+		seq := sequenceField{field: field.Name, index: field.Index, name: parts[0]}
+		for _, opt := range parts[1:] {
+			if !strings.HasPrefix(opt, "per=") {
+				panic("Unknown `sequence` tag option `" + opt + "` on field `" + typ.Name() + "." + field.Name + "`")
+			}
+			per, ok := typ.FieldByName(strings.TrimPrefix(opt, "per="))
+			if !ok {
+				panic("Field `" + strings.TrimPrefix(opt, "per=") + "` named by the sequence of `" + typ.Name() + "." + field.Name + "` does not exist")
+			}
+			seq.per = per.Index
+		}
+		sequences = append(sequences, seq)
+	}
+	return sequences
+}
+
+// SetCountersCollection changes the collection sequence counters are kept in. Defaults to "counters"
+func (z *Sleep) SetCountersCollection(name string) {
+	z.counters = name
+}
+
+// Sequence sets the start value and step of a named sequence. Sequences that are not configured start at 1 and step by 1.
+// Changing the options of a sequence that already handed out values does not renumber existing documents.
+// The start value only applies to counters that do not exist yet, a new step applies from the next value on.
+//
+// Integer fields tagged with `sequence:"name"` are given the next value of the named sequence when a document
+// created with CreateDoc is saved for the first time, unless the field was set to something other than 0.
+// Documents that were read from the database are never given a new value. The value is fetched atomically from
+// the counters collection, so it is unique even with many concurrent writers.
+//
+// Adding `per=Field` keeps a separate sequence for every value of another field, e.g. one per tenant.
+//
+//	type Invoice struct {
+//		Sleep.Document `bson:"-"`
+//		Id     bson.ObjectId `bson:"_id"`
+//		Tenant string
+//		Number int64         `sequence:"invoice,per=Tenant"`
+//	}
+//
+//	sleep.Sequence("invoice", Sleep.SequenceOptions{Start: Sleep.Int64(1000)})
+func (z *Sleep) Sequence(name string, opts SequenceOptions) {
+	z.sequences[name] = opts
+}
+
+// NextSequence atomically fetches the next value of the named sequence. The key partitions the sequence;
+// pass an empty string for an unpartitioned sequence.
+func (z *Sleep) NextSequence(name string, key string) (int64, error) {
+	opts := z.sequences[name]
+	start := int64(1)
+	if opts.Start != nil {
+		start = *opts.Start
+	}
+	if opts.Step == 0 {
+		opts.Step = 1
+	}
+	id := name
+	if key != "" {
+		id = name + "/" + key
+	}
+
+	//the counter keeps the offset it was created with and the sum of the steps taken since,
+	//so the first value is the start value whatever the step
+	counter := struct {
+		Offset int64 `bson:"offset"`
+		Seq    int64 `bson:"seq"`
+	}{}
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"seq": opts.Step}, "$setOnInsert": bson.M{"offset": start - opts.Step}},
+		Upsert: true, ReturnNew: true}
+	_, err := z.Db.C(z.counters).FindId(id).Apply(change, &counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Offset + counter.Seq, nil
+}
+
+// assignSequences gives every zero-valued sequence field of a new document its next value. Documents that were
+// saved or read from the database keep their values, even 0, which a sequence starting at 0 hands out.
+func (m *Model) assignSequences(schema interface{}) error {
+	if document := documentOf(schema); document.Model != nil && !document.isNew {
+		return nil
+	}
+	val := reflect.ValueOf(schema).Elem()
+	for _, seq := range m.sequences {
+		field := val.FieldByIndex(seq.index)
+		if !field.IsZero() {
+			continue
+		}
+		key := ""
+		if seq.per != nil {
+			key = fmt.Sprint(val.FieldByIndex(seq.per).Interface())
+		}
+		next, err := m.z.NextSequence(seq.name, key)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(next).Convert(field.Type()))
+	}
+	return nil
+}
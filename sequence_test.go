@@ -0,0 +1,118 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testTicket struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Tenant   string
+	Number   int64 `sequence:"ticket,per=Tenant"`
+}
+
+func TestNewSequences(t *testing.T) {
+	seqs := newSequences(reflect.TypeOf(testTicket{}))
+	if len(seqs) != 1 || seqs[0].name != "ticket" || !reflect.DeepEqual(seqs[0].per, []int{2}) {
+		t.Fatalf("got %+v", seqs)
+	}
+
+	type notInteger struct {
+		Number string `sequence:"n"`
+	}
+	type unknownOption struct {
+		Number int `sequence:"n,every=Tenant"`
+	}
+	type missingPer struct {
+		Number int `sequence:"n,per=Tenant"`
+	}
+	for _, schema := range []interface{}{notInteger{}, unknownOption{}, missingPer{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T did not panic", schema)
+				}
+			}()
+			newSequences(reflect.TypeOf(schema))
+		}()
+	}
+}
+
+func TestSequences(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Tickets := z.Register(testTicket{}, "tickets")
+
+	z.Sequence("zero", SequenceOptions{Start: Int64(0), Step: 5})
+	for _, want := range []int64{0, 5, 10} {
+		n, err := z.NextSequence("zero", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("got %d, want %d", n, want)
+		}
+	}
+	//the start value only applies to new counters
+	z.Sequence("zero", SequenceOptions{Start: Int64(100), Step: 5})
+	if n, _ := z.NextSequence("zero", ""); n != 15 {
+		t.Fatalf("got %d after changing the start value, want 15", n)
+	}
+
+	z.Sequence("ticket", SequenceOptions{Start: Int64(1000)})
+	for _, tenant := range []string{"a", "a", "b"} {
+		ticket := &testTicket{Tenant: tenant}
+		Tickets.CreateDoc(ticket)
+		if err := ticket.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tickets := []*testTicket{}
+	if err := Tickets.Find(nil).Sort("tenant", "number").Exec(&tickets); err != nil {
+		t.Fatal(err)
+	}
+	numbers := []int64{}
+	for _, ticket := range tickets {
+		numbers = append(numbers, ticket.Number)
+	}
+	if !reflect.DeepEqual(numbers, []int64{1000, 1001, 1000}) {
+		t.Fatalf("got numbers %v", numbers)
+	}
+
+	//a document given 0 by its sequence keeps it when it is saved again
+	z.Sequence("ticket", SequenceOptions{Start: Int64(0)})
+	first := &testTicket{Tenant: "c"}
+	Tickets.CreateDoc(first)
+	for i := 0; i < 2; i++ {
+		if err := first.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if first.Number != 0 {
+			t.Fatalf("got number %d after saving %d times", first.Number, i+1)
+		}
+	}
+	second := &testTicket{Tenant: "c"}
+	Tickets.CreateDoc(second)
+	if err := second.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if second.Number != 1 {
+		t.Fatalf("got number %d for the second ticket", second.Number)
+	}
+}
+
+func TestSequencesOfFoundDocuments(t *testing.T) {
+	z := offlineSleep()
+	Tickets := z.Register(testTicket{}, "tickets")
+	//a document read from the database is never numbered, there is no server to number it here
+	ticket := &testTicket{Tenant: "a"}
+	z.conditionDoc(ticket)
+	if err := Tickets.assignSequences(ticket); err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Number != 0 {
+		t.Fatalf("got number %d", ticket.Number)
+	}
+}
@@ -4,8 +4,10 @@
 package Sleep
 
 import (
-	"labix.org/v2/mgo"
-	"labix.org/v2/mgo/bson"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"os"
 	"reflect"
 )
 
@@ -20,22 +22,49 @@ type Sleep struct {
 	documents map[string]Document
 	models    map[string]*Model
 	modelTag  string
+	devMode   bool
+	logger    *log.Logger
+	//functions that can be named in `default` tags
+	defaultFuncs map[string]func() interface{}
+	counters     string
+	sequences    map[string]SequenceOptions
+	plugins      []plugin
 }
 
 // New returns a new intance of the Sleep type
 func New(session *mgo.Session, dbName string) *Sleep {
-	sleep := &Sleep{Db: session.DB(dbName), modelTag: "model"}
+	sleep := &Sleep{Db: session.DB(dbName), modelTag: "model",
+		logger: log.New(os.Stderr, "Sleep: ", log.LstdFlags)}
 	sleep.documents = make(map[string]Document)
 	sleep.models = make(map[string]*Model)
+	sleep.defaultFuncs = make(map[string]func() interface{})
+	sleep.counters = "counters"
+	sleep.sequences = make(map[string]SequenceOptions)
 	return sleep
 }
 
 // SetModelTag changes the default tag key of `model` to an arbitrary key.
 // This value is read to make relationships for populting based on ObjectIds
+//
+// It must be called before the models are registered.
 func (z *Sleep) SetModelTag(key string) {
 	z.modelTag = key
 }
 
+// SetDevMode turns development mode on or off. It is off by default.
+//
+// While in development mode every Query.Exec, including the sub-queries run by Populate, is first explained
+// and a warning is logged whenever the server would perform a full collection scan to satisfy it.
+// This doubles the number of round trips to the database and should not be enabled in production.
+func (z *Sleep) SetDevMode(on bool) {
+	z.devMode = on
+}
+
+// SetLogger replaces the logger that Sleep writes its warnings to. The default logger writes to os.Stderr
+func (z *Sleep) SetLogger(logger *log.Logger) {
+	z.logger = logger
+}
+
 // Register registers a given schema and its corresponding collection name with Sleep.
 // All schemas MUST be registered using this function.
 // Function will return a pointer to the Sleep.Model value for this model
@@ -52,34 +81,61 @@ func (z *Sleep) Register(schema interface{}, collectionName string) *Model {
 	}
 
 	model := newModel(z.Db.C(collectionName), z)
+	model.name = structName
+	model.idType = idField.Type()
+	model.params = newParamFields(typ)
+	model.softDelete = newSoftDelete(typ)
+	model.refs = newReferences(typ, z.modelTag)
+	model.defaults = newDefaults(z, typ)
+	model.sequences = newSequences(typ)
+	model.version = newSchemaVersion(typ)
+	model.discriminator = newDiscriminator(typ)
+	model.indexes = newIndexes(typ)
+	if ttl, ok := model.softDelete.ttlIndex(); ok {
+		model.indexes = append(model.indexes, ttl)
+	}
+	model.schema = newSchema(model, typ)
+	model.info = newTypeInfo(typ, model.refs)
 	z.models[structName] = model
 
 	z.documents[structName] = Document{C: z.Db.C(collectionName),
 		isQueried: true, schemaStruct: schema, Model: model,
-		populated: make(map[string]interface{}), Found: true}
+		Found: true}
 
 	return model
 }
 
-// CreateDoc conditions an instance of the model to become a document. Will create an ObjectId for the document.
+// CreateDoc conditions an instance of the model to become a document. Will create an ObjectId for the document,
+// or an Id from the model's generator. See Model.SetIdGenerator
+// Fields with a `default` tag that hold their zero value are set to their default value. See Sleep.RegisterDefault
 //
 // See Model.CreateDoc. They are the same
 func (z *Sleep) CreateDoc(doc interface{}) {
+	z.conditionDoc(doc)
+	documentOf(doc).isNew = true
+	model := z.models[reflect.TypeOf(doc).Elem().Name()]
+	model.discriminator.set(doc)
+	model.applyZeroDefaults(doc)
+	model.assignId(doc)
+}
+
+// conditionDoc sets the Sleep.Document field of a schema value without touching any of its other fields
+func (z *Sleep) conditionDoc(doc interface{}) {
 	typ := reflect.TypeOf(doc).Elem()
 	structName := typ.Name()
-	document := z.documents[structName]
-
+	document := z.document(structName)
 	document.schema = doc
 	document.Model = z.models[structName]
-	document.Virtual = newVirtual()
-
-	val := reflect.ValueOf(doc).Elem()
-	docVal := val.FieldByName("Document")
-	docVal.Set(reflect.ValueOf(document))
+	*documentOf(doc) = document
+}
 
-	idField := reflect.ValueOf(doc).Elem().FieldByName("Id")
-	id := bson.NewObjectId()
-	idField.Set(reflect.ValueOf(id))
+// document returns a copy of the document template of a registered schema. Every copy gets its own
+// populated fields and virtuals, so documents never share them.
+func (z *Sleep) document(name string) Document {
+	document := z.documents[name]
+	document.populated = make(map[string]interface{})
+	document.Virtual = newVirtual()
+	return document
 }
 
 // C gives access to the underlying *mgo.Collection value for a model.
@@ -108,6 +164,8 @@ func ObjectId(id string) bson.ObjectId {
 //Function will take types string or bson.ObjectId represented by a type interface{} and returns
 //a type bson.ObjectId. Will panic if wrong type is passed. Will also panic if the string
 //is not a valid representation of an ObjectId
+//
+//Only used for models with ObjectId Ids. See Model.id
 func getObjectId(id interface{}) bson.ObjectId {
 	var idActual bson.ObjectId
 	switch id.(type) {
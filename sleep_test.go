@@ -0,0 +1,99 @@
+package Sleep
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSleep returns a Sleep value on a fresh database of the MongoDB server named by the SLEEP_TEST_MONGO
+// environment variable, e.g. "localhost". Tests that need a server are skipped when it is not set.
+// The returned function drops the database.
+func testSleep(t testing.TB) (*Sleep, func()) {
+	url := os.Getenv("SLEEP_TEST_MONGO")
+	if url == "" {
+		t.Skip("SLEEP_TEST_MONGO is not set")
+	}
+	session, err := mgo.DialWithTimeout(url, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("sleep_test_%d", time.Now().UnixNano())
+	return New(session, name), func() {
+		session.DB(name).DropDatabase()
+		session.Close()
+	}
+}
+
+// offlineSleep returns a Sleep value without a session, for tests that never talk to a server
+func offlineSleep() *Sleep {
+	return New(nil, "sleep_test")
+}
+
+type testPerson struct {
+	Document `bson:"-"`
+	Id       bson.ObjectId `bson:"_id"`
+	Name     string
+	Friend   bson.ObjectId   `model:"testPerson"`
+	Friends  []bson.ObjectId `model:"testPerson"`
+}
+
+func TestDocumentsDoNotSharePopulated(t *testing.T) {
+	z := offlineSleep()
+	z.Register(testPerson{}, "people")
+	a, b := &testPerson{}, &testPerson{}
+	z.CreateDoc(a)
+	z.CreateDoc(b)
+	a.populated["Friend"] = &testPerson{}
+	if _, ok := b.populated["Friend"]; ok {
+		t.Fatal("documents share their populated fields")
+	}
+	if a.Virtual == b.Virtual {
+		t.Fatal("documents share their virtuals")
+	}
+}
+
+func TestConcurrentPopulate(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	People := z.Register(testPerson{}, "people")
+
+	friend := &testPerson{Name: "friend"}
+	People.CreateDoc(friend)
+	if err := friend.Save(); err != nil {
+		t.Fatal(err)
+	}
+	person := &testPerson{Name: "person", Friend: friend.Id}
+	People.CreateDoc(person)
+	if err := person.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	query := People.FindId(person.Id).Populate("Friend")
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := &testPerson{}
+			if err := query.Exec(result); err != nil {
+				errs <- err
+				return
+			}
+			populated := &testPerson{}
+			if !result.Populated("Friend", populated) || populated.Name != "friend" {
+				errs <- fmt.Errorf("Friend was not populated")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,171 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"time"
+)
+
+const (
+	excludeDeleted = iota
+	withDeleted
+	onlyDeleted
+)
+
+// softDelete describes the field that marks a document of a soft deleting model as deleted
+type softDelete struct {
+	name   string
+	index  []int
+	retain time.Duration
+}
+
+// newSoftDelete finds the field tagged with `softdelete:"true"`. It returns nil if the schema does not opt into soft deletion.
+//
+// The field must be of type *time.Time. An optional `retain` tag with a duration (e.g. `retain:"2160h"`)
+// declares a TTL index that purges soft deleted documents once they have been deleted for that long.
+func newSoftDelete(typ reflect.Type) *softDelete {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Tag.Get("softdelete") != "true" {
+			continue
+		}
+		if field.Type != reflect.TypeOf(&time.Time{}) {
+			panic("The soft delete field `" + typ.Name() + "." + field.Name + "` must be of type *time.Time")
+		}
+		soft := &softDelete{name: bsonName(field), index: field.Index}
+		if retain := field.Tag.Get("retain"); retain != "" {
+			d, err := time.ParseDuration(retain)
+			if err != nil {
+				panic("Invalid `retain` duration on field `" + typ.Name() + "." + field.Name + "`: " + err.Error())
+			}
+			soft.retain = d
+		}
+		return soft
+	}
+	return nil
+}
+
+// ttlIndex returns the TTL index that purges old soft deleted documents, if a retention period was declared
+func (s *softDelete) ttlIndex() (mgo.Index, bool) {
+	if s == nil || s.retain == 0 {
+		return mgo.Index{}, false
+	}
+	return mgo.Index{Key: []string{s.name}, ExpireAfter: s.retain}, true
+}
+
+// filter returns the condition selecting documents according to the query's deleted mode
+func (s *softDelete) filter(mode int) interface{} {
+	switch mode {
+	case excludeDeleted:
+		return M{s.name: nil}
+	case onlyDeleted:
+		return M{s.name: M{"$ne": nil}}
+	}
+	return nil
+}
+
+// mark sets the deleted marker on a document value and returns the update that does the same in the database
+func (s *softDelete) mark(schema interface{}) M {
+	now := time.Now()
+	reflect.ValueOf(schema).Elem().FieldByIndex(s.index).Set(reflect.ValueOf(&now))
+	return M{"$set": M{s.name: now}}
+}
+
+// WithDeleted includes soft deleted documents in the results.
+// It has no effect on models that do not use soft deletion.
+func (q *Query) WithDeleted() *Query {
+	q = q.Clone()
+	q.deleted = withDeleted
+	return q
+}
+
+// OnlyDeleted limits the results to soft deleted documents.
+// It has no effect on models that do not use soft deletion.
+func (q *Query) OnlyDeleted() *Query {
+	q = q.Clone()
+	q.deleted = onlyDeleted
+	return q
+}
+
+// Restore brings back a soft deleted document by clearing its deleted marker.
+// Will panic if the document's model does not use soft deletion.
+func (d *Document) Restore() error {
+	soft := d.Model.softDelete
+	if soft == nil {
+		panic("Model `" + d.Model.name + "` does not use soft deletion")
+	}
+	op := &Operation{Op: OpUpdate, Model: d.Model, Document: d, Doc: d.schema, Id: docId(d.schema),
+		Change: M{"$unset": M{soft.name: 1}}}
+	err := d.Model.z.run(op, func() error {
+		return d.Model.C.UpdateId(op.Id, op.Change)
+	})
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(d.schema).Elem().FieldByIndex(soft.index).Set(reflect.Zero(reflect.TypeOf(&time.Time{})))
+	return nil
+}
+
+// ForceRemoveId removes the document with the given Id from the collection, even if the model uses soft deletion.
+// As with Document.Remove, it fails with ErrReferenced if the document is held by a restrict rule, and the
+// references to the document are nullified or cascaded according to their `ondelete` rules.
+func (m *Model) ForceRemoveId(id interface{}) error {
+	return m.forceRemoveId(m.id(id))
+}
+
+// forceRemoveId runs forceRemove through the plugins
+func (m *Model) forceRemoveId(id interface{}) error {
+	op := &Operation{Op: OpRemove, Model: m, Id: id}
+	return m.z.run(op, func() error {
+		return m.forceRemove(op.Id)
+	})
+}
+
+func (m *Model) forceRemove(id interface{}) error {
+	err := m.checkRestrict(id, make(map[refKey]bool))
+	if err != nil {
+		return err
+	}
+	err = m.C.RemoveId(id)
+	if err != nil {
+		return err
+	}
+	return m.applyDeleteRules(id)
+}
+
+// PurgeDeleted permanently removes the documents that were soft deleted more than the given duration ago.
+// Each document is removed as with ForceRemoveId. Documents that are still held by a restrict rule are left in place.
+// It returns the number of documents removed.
+//
+// Documents purged by the TTL index declared with the `retain` tag are removed by the server, which does not
+// apply the `ondelete` rules.
+func (m *Model) PurgeDeleted(olderThan time.Duration) (int, error) {
+	if m.softDelete == nil {
+		panic("Model `" + m.name + "` does not use soft deletion")
+	}
+	ids := []interface{}{}
+	doc := struct {
+		Id interface{} `bson:"_id"`
+	}{}
+	iter := m.C.Find(bson.M{m.softDelete.name: bson.M{"$lt": time.Now().Add(-olderThan)}}).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&doc) {
+		ids = append(ids, doc.Id)
+	}
+	err := iter.Close()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		err = m.forceRemoveId(id)
+		if err == ErrReferenced || err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,121 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testNote struct {
+	Document  `bson:"-"`
+	Id        bson.ObjectId `bson:"_id"`
+	Text      string
+	DeletedAt *time.Time `bson:"deletedAt" softdelete:"true" retain:"24h"`
+}
+
+func TestNewSoftDelete(t *testing.T) {
+	if newSoftDelete(reflect.TypeOf(testPerson{})) != nil {
+		t.Fatal("schema without a softdelete tag uses soft deletion")
+	}
+	soft := newSoftDelete(reflect.TypeOf(testNote{}))
+	if soft == nil || soft.name != "deletedAt" || soft.retain != 24*time.Hour {
+		t.Fatalf("got %+v", soft)
+	}
+	index, ok := soft.ttlIndex()
+	if !ok || index.Key[0] != "deletedAt" || index.ExpireAfter != 24*time.Hour {
+		t.Fatalf("got TTL index %+v", index)
+	}
+
+	type wrongType struct {
+		Id      bson.ObjectId `bson:"_id"`
+		Deleted bool          `softdelete:"true"`
+	}
+	type badRetain struct {
+		Id      bson.ObjectId `bson:"_id"`
+		Deleted *time.Time    `softdelete:"true" retain:"forever"`
+	}
+	for _, schema := range []interface{}{wrongType{}, badRetain{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T did not panic", schema)
+				}
+			}()
+			newSoftDelete(reflect.TypeOf(schema))
+		}()
+	}
+}
+
+func TestSoftDeleteFilters(t *testing.T) {
+	z := offlineSleep()
+	Notes := z.Register(testNote{}, "notes")
+	tests := []struct {
+		query  *Query
+		filter interface{}
+	}{
+		{Notes.Find(nil), M{"deletedAt": nil}},
+		{Notes.Find(nil).WithDeleted(), nil},
+		{Notes.Find(nil).OnlyDeleted(), M{"deletedAt": M{"$ne": nil}}},
+	}
+	for i, test := range tests {
+		if got := test.query.filter(); !reflect.DeepEqual(got, test.filter) {
+			t.Errorf("%d: got filter %#v, want %#v", i, got, test.filter)
+		}
+	}
+}
+
+func TestSoftDeleteMark(t *testing.T) {
+	note := &testNote{}
+	soft := newSoftDelete(reflect.TypeOf(testNote{}))
+	change := soft.mark(note)
+	if note.DeletedAt == nil {
+		t.Fatal("the document was not marked")
+	}
+	if change["$set"].(M)["deletedAt"] != *note.DeletedAt {
+		t.Fatalf("got change %v", change)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Notes := z.Register(testNote{}, "notes")
+
+	note := &testNote{Text: "hello"}
+	Notes.CreateDoc(note)
+	if err := note.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := note.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	count := func(q *Query) int {
+		notes := []*testNote{}
+		if err := q.Exec(&notes); err != nil {
+			t.Fatal(err)
+		}
+		return len(notes)
+	}
+	if count(Notes.Find(nil)) != 0 || count(Notes.Find(nil).WithDeleted()) != 1 || count(Notes.Find(nil).OnlyDeleted()) != 1 {
+		t.Fatal("soft deleted note is not filtered as expected")
+	}
+
+	if err := note.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if note.DeletedAt != nil || count(Notes.Find(nil)) != 1 {
+		t.Fatal("note was not restored")
+	}
+
+	if err := Notes.RemoveId(note.Id); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Notes.PurgeDeleted(0)
+	if err != nil || n != 1 {
+		t.Fatalf("purged %d notes: %v", n, err)
+	}
+	if count(Notes.Find(nil).WithDeleted()) != 0 {
+		t.Fatal("note was not purged")
+	}
+}
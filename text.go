@@ -0,0 +1,39 @@
+package Sleep
+
+// scoreKey is the key the text search score is projected under
+const scoreKey = "_score"
+
+// Search runs a full-text search for the given terms using the collection's text index. The language
+// determines the stop words and stemming rules used. Pass an empty string to use the index's default language.
+//
+// The index is declared by tagging the schema's fields with their weights and created using Model.EnsureIndexes:
+//
+//	type Article struct {
+//		Sleep.Document `bson:"-"`
+//		Id    bson.ObjectId `bson:"_id"`
+//		Title string        `text:"10"`
+//		Body  string        `text:"1"`
+//	}
+//
+//	articles := []*Article{}
+//	Article.Find(bson.M{"published": true}).Search("mongodb odm", "english").SortByRelevance().Exec(&articles)
+//	score, _ := articles[0].Virtual.GetFloat("score")
+//
+// The relevance score of each result is stored in its Virtual as a float named "score".
+func (q *Query) Search(terms string, language string) *Query {
+	q = q.Clone()
+	text := M{"$search": terms}
+	if language != "" {
+		text["$language"] = language
+	}
+	q.query = andFilter(q.query, M{"$text": text})
+	q.meta[scoreKey] = M{"$meta": "textScore"}
+	q.virtuals[scoreKey] = "score"
+	return q
+}
+
+// SortByRelevance sorts the results of a text search by their score, best match first.
+// Like Sort, it is appended to any existing sort fields.
+func (q *Query) SortByRelevance() *Query {
+	return q.Sort("$textScore:" + scoreKey)
+}
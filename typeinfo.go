@@ -0,0 +1,58 @@
+package Sleep
+
+import (
+	"reflect"
+	"sync"
+)
+
+// typeInfo holds what Sleep needs to work with values of a schema type. It is computed once, when the
+// schema is registered, so that fields and methods are not looked up by name on every call.
+type typeInfo struct {
+	// document and id are the indexes of the Sleep.Document and Id fields
+	document []int
+	id       []int
+	// hooks maps the hooks in the method set of the pointer type to their method index, including hooks
+	// promoted from embedded structs and the stand-ins of Sleep.Document
+	hooks map[string]int
+	// refs maps the populate paths of reference fields to the fields
+	refs map[string]reference
+}
+
+// typeInfos holds the typeInfo of every registered schema type
+var (
+	typeInfosMutex sync.RWMutex
+	typeInfos      = make(map[reflect.Type]*typeInfo)
+)
+
+func newTypeInfo(typ reflect.Type, refs []reference) *typeInfo {
+	info := &typeInfo{hooks: make(map[string]int), refs: make(map[string]reference, len(refs))}
+	documentField, _ := typ.FieldByName("Document")
+	info.document = documentField.Index
+	idField, _ := typ.FieldByName("Id")
+	info.id = idField.Index
+	for _, name := range hookNames {
+		if method, ok := reflect.PtrTo(typ).MethodByName(name); ok {
+			info.hooks[name] = method.Index
+		}
+	}
+	for _, ref := range refs {
+		info.refs[ref.field] = ref
+	}
+
+	typeInfosMutex.Lock()
+	typeInfos[typ] = info
+	typeInfosMutex.Unlock()
+	return info
+}
+
+// infoOf returns the typeInfo of a pointer to a schema, or nil if its type was never registered
+func infoOf(schema interface{}) *typeInfo {
+	typ := reflect.TypeOf(schema)
+	if typ.Kind() != reflect.Ptr {
+		return nil
+	}
+	typeInfosMutex.RLock()
+	info := typeInfos[typ.Elem()]
+	typeInfosMutex.RUnlock()
+	return info
+}
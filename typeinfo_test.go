@@ -0,0 +1,142 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testBase struct {
+	Document `bson:"-"`
+	saves    int
+}
+
+func (b *testBase) PreSave() {
+	b.saves++
+}
+
+type testArticle struct {
+	testBase `bson:",inline"`
+	Id       bson.ObjectId `bson:"_id"`
+	Title    string
+}
+
+func TestPromotedHooks(t *testing.T) {
+	z := offlineSleep()
+	info := z.Register(testArticle{}, "articles").info
+	if _, ok := info.hooks["PreSave"]; !ok {
+		t.Fatalf("the promoted hook is missing from %v", info.hooks)
+	}
+	if infoOf(&testArticle{}) != info {
+		t.Fatal("the registered typeInfo is not the one looked up")
+	}
+	article := &testArticle{}
+	z.CreateDoc(article)
+	err := callHook(article, "PreSave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.saves != 1 {
+		t.Fatal("the promoted hook was not called")
+	}
+	//the stand-ins of Sleep.Document do nothing
+	if err := callHook(article, "Validate"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkCallHook(b *testing.B) {
+	z := offlineSleep()
+	z.Register(testArticle{}, "articles")
+	article := &testArticle{}
+	z.CreateDoc(article)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		callHook(article, "PreSave")
+	}
+}
+
+// testDraft is never registered
+type testDraft struct {
+	testBase `bson:",inline"`
+	Id       bson.ObjectId `bson:"_id"`
+}
+
+func BenchmarkCallHookUnregistered(b *testing.B) {
+	//without a typeInfo the hook is looked up by name on every call
+	draft := &testDraft{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		callHook(draft, "PreSave")
+	}
+}
+
+func BenchmarkDocumentOf(b *testing.B) {
+	z := offlineSleep()
+	z.Register(testArticle{}, "articles")
+	article := &testArticle{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		documentOf(article)
+	}
+}
+
+func BenchmarkConditionDoc(b *testing.B) {
+	z := offlineSleep()
+	z.Register(testArticle{}, "articles")
+	article := &testArticle{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z.conditionDoc(article)
+	}
+}
+
+func BenchmarkExec(b *testing.B) {
+	benchmarkExec(b, true)
+}
+
+// BenchmarkExecReflection runs the same query with fields and hooks looked up by name, as before the typeInfo cache
+func BenchmarkExecReflection(b *testing.B) {
+	benchmarkExec(b, false)
+}
+
+func benchmarkExec(b *testing.B, cached bool) {
+	z, done := testSleep(b)
+	defer done()
+	Articles := z.Register(testArticle{}, "articles")
+	if !cached {
+		typ := reflect.TypeOf(testArticle{})
+		typeInfosMutex.Lock()
+		delete(typeInfos, typ)
+		typeInfosMutex.Unlock()
+		info := Articles.info
+		Articles.info = nil
+		defer func() {
+			typeInfosMutex.Lock()
+			typeInfos[typ] = info
+			typeInfosMutex.Unlock()
+			Articles.info = info
+		}()
+	}
+	const count = 10000
+	bulk := Articles.C.Bulk()
+	bulk.Unordered()
+	for i := 0; i < count; i++ {
+		bulk.Insert(bson.M{"_id": bson.NewObjectId(), "title": "article"})
+	}
+	_, err := bulk.Run()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		articles := []*testArticle{}
+		err := Articles.Find(nil).Exec(&articles)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(articles) != count {
+			b.Fatalf("got %d documents", len(articles))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package Sleep
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strconv"
+)
+
+// schemaVersion describes the field of a schema that records the shape of a stored document
+type schemaVersion struct {
+	name    string
+	current int
+	// upgrades[v] turns a document of version v into one of version v+1
+	upgrades map[int]func(doc bson.M) error
+	// writeBack is unset when upgraded documents must not be saved. See Model.SetUpgradeWriteBack
+	writeBack bool
+}
+
+// ErrUpgraded is returned by Document.Save for a document that was upgraded from an older schema version when
+// write-back is disabled for its model. Nothing is written when it is returned. See Model.SetUpgradeWriteBack
+var ErrUpgraded = errors.New("Sleep: document was upgraded from an older schema version and write-back is disabled")
+
+// storedDoc holds what Sleep read from a stored document besides its decoded value
+type storedDoc struct {
+	elems    bson.RawD
+	upgraded bool
+}
+
+// newSchemaVersion finds the field tagged with `schemaversion:"<current version>"`. It returns nil if there is none.
+func newSchemaVersion(typ reflect.Type) *schemaVersion {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("schemaversion")
+		if tag == "" {
+			continue
+		}
+		current, err := strconv.Atoi(tag)
+		if err != nil || current < 1 {
+			panic("The `schemaversion` tag on field `" + typ.Name() + "." + field.Name + "` must be a positive version number")
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+		default:
+			panic("Schema version field `" + typ.Name() + "." + field.Name + "` must be an integer")
+		}
+		return &schemaVersion{name: bsonName(field), current: current, upgrades: make(map[int]func(bson.M) error),
+			writeBack: true}
+	}
+	return nil
+}
+
+// Upgrade registers the function that upgrades stored documents from the given schema version to the next one.
+// Documents read by a query that are older than the version declared by the schema's `schemaversion` tag are passed
+// through every upgrade function between their version and the current one before they are decoded. Documents without
+// a version field are at version 0. The upgraded document is written back the next time it is saved, unless
+// write-back was disabled with Model.SetUpgradeWriteBack.
+//
+// Documents read by a query with Query.Select are not upgraded, since the fields the upgrade functions need may
+// not have been selected.
+//
+// Will panic if the schema has no field tagged with `schemaversion`.
+//
+//	type User struct {
+//		Sleep.Document `bson:"-"`
+//		Id        bson.ObjectId `bson:"_id"`
+//		Version   int           `schemaversion:"2"`
+//		FirstName string
+//		LastName  string
+//	}
+//
+//	//version 0 stored the full name in a single field
+//	User.Upgrade(0, func(doc bson.M) error {
+//		name, _ := doc["name"].(string)
+//		parts := strings.SplitN(name, " ", 2)
+//		doc["firstname"] = parts[0]
+//		if len(parts) == 2 {
+//			doc["lastname"] = parts[1]
+//		}
+//		delete(doc, "name")
+//		return nil
+//	})
+//	User.Upgrade(1, ...)
+func (m *Model) Upgrade(from int, fn func(doc bson.M) error) {
+	if m.version == nil {
+		panic("Schema `" + m.name + "` has no field tagged with `schemaversion`")
+	}
+	m.version.upgrades[from] = fn
+}
+
+// SetUpgradeWriteBack sets whether documents that were upgraded when they were read are written back in their new
+// shape when they are saved. It is enabled by default. When it is disabled the stored documents keep their old
+// version, which is useful while other services still read that version, and Document.Save returns ErrUpgraded
+// for upgraded documents.
+//
+// Will panic if the schema has no field tagged with `schemaversion`.
+func (m *Model) SetUpgradeWriteBack(writeBack bool) {
+	if m.version == nil {
+		panic("Schema `" + m.name + "` has no field tagged with `schemaversion`")
+	}
+	m.version.writeBack = writeBack
+}
+
+// decodeRaw decodes a stored document into result. If upgrade is set the document is first upgraded when it is
+// older than the current schema version. Partial documents must not be upgraded.
+func (m *Model) decodeRaw(raw bson.Raw, result interface{}, upgrade bool) (storedDoc, error) {
+	stored := storedDoc{}
+	if upgrade && m != nil && m.version != nil {
+		doc := bson.M{}
+		err := raw.Unmarshal(&doc)
+		if err != nil {
+			return stored, err
+		}
+		upgraded, err := m.version.upgrade(doc)
+		if err != nil {
+			return stored, err
+		}
+		if upgraded {
+			data, err := bson.Marshal(doc)
+			if err != nil {
+				return stored, err
+			}
+			raw = bson.Raw{Kind: 0x03, Data: data}
+			stored.upgraded = true
+		}
+	}
+	err := raw.Unmarshal(result)
+	if err != nil {
+		return stored, err
+	}
+	err = raw.Unmarshal(&stored.elems)
+	return stored, err
+}
+
+// upgrade runs the upgrade functions on a stored document. It reports whether the document was changed
+func (v *schemaVersion) upgrade(doc bson.M) (bool, error) {
+	version := toInt(doc[v.name])
+	if version >= v.current {
+		return false, nil
+	}
+	for ; version < v.current; version++ {
+		fn, ok := v.upgrades[version]
+		if !ok {
+			return false, fmt.Errorf("Sleep: no upgrade registered from schema version %d of document %v", version, doc["_id"])
+		}
+		err := fn(doc)
+		if err != nil {
+			return false, err
+		}
+	}
+	doc[v.name] = v.current
+	return true, nil
+}
+
+// IsUpgraded reports whether the document was upgraded from an older schema version when it was read.
+// The upgrade is only persisted once the document is saved. See Model.Upgrade
+func (d *Document) IsUpgraded() bool {
+	return d.upgraded
+}
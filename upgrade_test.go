@@ -0,0 +1,139 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"testing"
+)
+
+type testProfile struct {
+	Document  `bson:"-"`
+	Id        bson.ObjectId `bson:"_id"`
+	Version   int           `bson:"version" schemaversion:"2"`
+	FirstName string        `bson:"firstname"`
+	LastName  string        `bson:"lastname"`
+}
+
+func registerProfiles(z *Sleep) *Model {
+	Profiles := z.Register(testProfile{}, "profiles")
+	Profiles.Upgrade(0, func(doc bson.M) error {
+		name, _ := doc["name"].(string)
+		parts := strings.SplitN(name, " ", 2)
+		doc["firstname"] = parts[0]
+		if len(parts) == 2 {
+			doc["lastname"] = parts[1]
+		}
+		delete(doc, "name")
+		return nil
+	})
+	Profiles.Upgrade(1, func(doc bson.M) error {
+		doc["lastname"] = strings.ToUpper(doc["lastname"].(string))
+		return nil
+	})
+	return Profiles
+}
+
+func rawDoc(t *testing.T, doc interface{}) bson.Raw {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.Raw{Kind: 0x03, Data: data}
+}
+
+func TestDecodeRawUpgrades(t *testing.T) {
+	Profiles := registerProfiles(offlineSleep())
+	raw := rawDoc(t, bson.M{"_id": bson.NewObjectId(), "name": "Ada Lovelace"})
+
+	profile := &testProfile{}
+	stored, err := Profiles.decodeRaw(raw, profile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.upgraded || profile.Version != 2 || profile.FirstName != "Ada" || profile.LastName != "LOVELACE" {
+		t.Fatalf("got %+v, upgraded %v", profile, stored.upgraded)
+	}
+
+	current := &testProfile{}
+	stored, err = Profiles.decodeRaw(rawDoc(t, bson.M{"version": 2, "firstname": "Ada"}), current, true)
+	if err != nil || stored.upgraded || current.FirstName != "Ada" {
+		t.Fatalf("a current document was upgraded: %+v, %v", current, err)
+	}
+}
+
+func TestDecodeRawWithoutUpgrade(t *testing.T) {
+	Profiles := registerProfiles(offlineSleep())
+	//a selection without the version field reads as version 0, it must not be upgraded
+	raw := rawDoc(t, bson.M{"_id": bson.NewObjectId(), "firstname": "Ada"})
+	profile := &testProfile{}
+	stored, err := Profiles.decodeRaw(raw, profile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.upgraded || profile.FirstName != "Ada" || profile.Version != 0 {
+		t.Fatalf("got %+v, upgraded %v", profile, stored.upgraded)
+	}
+}
+
+func TestMissingUpgrade(t *testing.T) {
+	z := offlineSleep()
+	Profiles := z.Register(testProfile{}, "profiles")
+	Profiles.Upgrade(0, func(doc bson.M) error { return nil })
+	_, err := Profiles.decodeRaw(rawDoc(t, bson.M{"name": "Ada"}), &testProfile{}, true)
+	if err == nil || !strings.Contains(err.Error(), "from schema version 1") {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestSaveWithoutWriteBack(t *testing.T) {
+	Profiles := registerProfiles(offlineSleep())
+	Profiles.SetUpgradeWriteBack(false)
+	profile := &testProfile{}
+	Profiles.CreateDoc(profile)
+	profile.upgraded = true
+	if err := profile.Save(); err != ErrUpgraded {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestUpgradeOnRead(t *testing.T) {
+	z, done := testSleep(t)
+	defer done()
+	Profiles := registerProfiles(z)
+	id := bson.NewObjectId()
+	err := Profiles.C.Insert(bson.M{"_id": id, "name": "Ada Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := &testProfile{}
+	err = Profiles.FindId(id).Exec(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !profile.IsUpgraded() || profile.LastName != "LOVELACE" {
+		t.Fatalf("got %+v", profile)
+	}
+
+	partial := &testProfile{}
+	err = Profiles.FindId(id).Select(bson.M{"firstname": 1}).Exec(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial.IsUpgraded() {
+		t.Fatal("a partial document was upgraded")
+	}
+
+	err = profile.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := bson.M{}
+	err = Profiles.C.FindId(id).One(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored["version"] != 2 || stored["name"] != nil || stored["lastname"] != "LOVELACE" {
+		t.Fatalf("the upgrade was not written back: %v", stored)
+	}
+}
@@ -0,0 +1,239 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+)
+
+// WatchOptions configures a change stream started with Model.Watch
+type WatchOptions struct {
+	// FullDocument controls whether update events carry the current version of the whole document.
+	// Set it to "updateLookup" to have ChangeEvent.Document populated for updates, too.
+	FullDocument string
+	// ResumeAfter starts the stream right after the event with the given resume token.
+	// It takes precedence over a token loaded from TokenStore.
+	ResumeAfter *bson.Raw
+	// BatchSize sets the number of events the server returns at a time
+	BatchSize int
+	// TokenStore, if set, is used to load the token to resume from when the stream starts and to
+	// persist the token of every event once it has been handled.
+	TokenStore TokenStore
+	// Name identifies the stream in the TokenStore. Defaults to the collection name.
+	Name string
+}
+
+// TokenStore persists change stream resume tokens so a stream can pick up where it left off after a restart
+type TokenStore interface {
+	// LoadToken returns the last saved token for the named stream, or nil if there is none
+	LoadToken(name string) (*bson.Raw, error)
+	// SaveToken saves the token for the named stream
+	SaveToken(name string, token bson.Raw) error
+}
+
+// ChangeEvent is a single change to a document in a watched collection
+type ChangeEvent struct {
+	// Operation is the type of the change: "insert", "update", "replace", "delete" or "invalidate"
+	Operation string
+	// Id is the _id of the changed document
+	Id interface{}
+	// Document is a pointer to the changed document, ready to be used as any other Sleep document.
+	// It is set for inserts and replaces, and for updates if WatchOptions.FullDocument is "updateLookup".
+	// It is nil otherwise.
+	Document interface{}
+	// UpdatedFields and RemovedFields describe the changes made by an update
+	UpdatedFields bson.M
+	RemovedFields []string
+	// Token is the resume token of this event
+	Token bson.Raw
+}
+
+type changeEvent struct {
+	Token             bson.Raw `bson:"_id"`
+	OperationType     string   `bson:"operationType"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	DocumentKey       bson.M   `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// ChangeStream is a stream of changes made to the documents of a model. Create one using Model.Watch
+type ChangeStream struct {
+	model   *Model
+	iter    *mgo.Iter
+	store   TokenStore
+	name    string
+	pending *bson.Raw
+	err     error
+}
+
+// Watch opens a change stream on the model's collection. Changes are decoded into the model's schema.
+// The pipeline filters or reshapes the events; pass nil to receive every change. Requires a replica set running MongoDB 3.6 or later.
+//
+// mgo has no native change stream support. The stream is an aggregation with a $changeStream stage read through
+// mgo's pipe cursor, which keeps asking the server for more events. This relies on the legacy getMore wire
+// operation, so it works with MongoDB 3.6 up to 5.0. A stream that fails, for example because the primary stepped
+// down, is not resumed automatically: call Watch again with the same TokenStore to pick up where it stopped.
+//
+// Example:
+//
+//	store := sleep.TokenCollection("resume_tokens")
+//	stream, err := User.Watch([]bson.M{{"$match": bson.M{"operationType": "update"}}},
+//		Sleep.WatchOptions{FullDocument: "updateLookup", TokenStore: store})
+//	if err != nil {
+//		//handle it
+//	}
+//	defer stream.Close()
+//
+//	event := Sleep.ChangeEvent{}
+//	for stream.Next(&event) {
+//		user := event.Document.(*User)
+//		//invalidate caches, update search index...
+//	}
+//	if err := stream.Err(); err != nil {
+//		//handle it
+//	}
+func (m *Model) Watch(pipeline []bson.M, opts WatchOptions) (*ChangeStream, error) {
+	stream := &ChangeStream{model: m, store: opts.TokenStore, name: opts.Name}
+	if stream.name == "" {
+		stream.name = m.C.Name
+	}
+
+	changeStream := bson.M{}
+	if opts.FullDocument != "" {
+		changeStream["fullDocument"] = opts.FullDocument
+	}
+	token := opts.ResumeAfter
+	if token == nil && stream.store != nil {
+		var err error
+		token, err = stream.store.LoadToken(stream.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if token != nil {
+		changeStream["resumeAfter"] = *token
+	}
+
+	stages := append([]bson.M{{"$changeStream": changeStream}}, pipeline...)
+	pipe := m.C.Pipe(stages)
+	if opts.BatchSize != 0 {
+		pipe = pipe.Batch(opts.BatchSize)
+	}
+	stream.iter = pipe.Iter()
+	if err := stream.iter.Err(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Next blocks until the next change is available and decodes it into event. It returns false if the stream
+// was closed, invalidated or an error occurred. Use Err to tell them apart.
+//
+// If a TokenStore was given, the token of the previous event is saved when Next is called,
+// so every event is delivered at least once even if the program is stopped while handling it.
+func (s *ChangeStream) Next(event *ChangeEvent) bool {
+	if s.err != nil {
+		return false
+	}
+	if !s.savePending() {
+		return false
+	}
+
+	raw := changeEvent{}
+	if !s.iter.Next(&raw) {
+		s.err = s.iter.Err()
+		return false
+	}
+
+	*event = ChangeEvent{Operation: raw.OperationType, Id: raw.DocumentKey["_id"], Token: raw.Token,
+		UpdatedFields: raw.UpdateDescription.UpdatedFields,
+		RemovedFields: raw.UpdateDescription.RemovedFields}
+	if raw.FullDocument.Kind == 0x03 {
+		doc, err := s.model.decode(raw.FullDocument)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		event.Document = doc
+	}
+	s.pending = &raw.Token
+	return raw.OperationType != "invalidate"
+}
+
+// Err returns the error that stopped the stream, if any
+func (s *ChangeStream) Err() error {
+	return s.err
+}
+
+// Close saves the token of the last event, if a TokenStore was given, and closes the stream
+func (s *ChangeStream) Close() error {
+	s.savePending()
+	err := s.iter.Close()
+	if s.err == nil {
+		s.err = err
+	}
+	return err
+}
+
+func (s *ChangeStream) savePending() bool {
+	if s.store == nil || s.pending == nil {
+		return true
+	}
+	s.err = s.store.SaveToken(s.name, *s.pending)
+	s.pending = nil
+	return s.err == nil
+}
+
+// decode unmarshals a raw document into a new value of the model's schema and conditions it as a found document
+func (m *Model) decode(raw bson.Raw) (interface{}, error) {
+	if m.iface != nil {
+		doc, err := (&Query{z: m.z}).decodeInterface(m, raw, m.iface)
+		if err != nil {
+			return nil, err
+		}
+		return doc.Interface(), nil
+	}
+	schemaType := reflect.TypeOf(m.z.documents[m.name].schemaStruct)
+	doc := reflect.New(schemaType).Interface()
+	stored, err := m.decodeRaw(raw, doc, true)
+	if err != nil {
+		return nil, err
+	}
+	m.z.conditionDoc(doc)
+	m.applyMissingDefaults(doc, stored.elems)
+	document := documentOf(doc)
+	document.upgraded = stored.upgraded
+	return doc, nil
+}
+
+// tokenCollection stores resume tokens in a MongoDB collection, one document per stream
+type tokenCollection struct {
+	c *mgo.Collection
+}
+
+// TokenCollection returns a TokenStore that keeps resume tokens in the given collection of Sleep's database
+func (z *Sleep) TokenCollection(name string) TokenStore {
+	return &tokenCollection{z.Db.C(name)}
+}
+
+func (t *tokenCollection) LoadToken(name string) (*bson.Raw, error) {
+	result := struct {
+		Token bson.Raw `bson:"token"`
+	}{}
+	err := t.c.FindId(name).One(&result)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result.Token, nil
+}
+
+func (t *tokenCollection) SaveToken(name string, token bson.Raw) error {
+	_, err := t.c.UpsertId(name, bson.M{"$set": bson.M{"token": token}})
+	return err
+}
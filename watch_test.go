@@ -0,0 +1,99 @@
+package Sleep
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+	"time"
+)
+
+// testReplicaSet is the same as testSleep for a replica set, named by the SLEEP_TEST_REPLSET environment variable,
+// e.g. "localhost:27017?replicaSet=rs0". Change streams only work on replica sets.
+func testReplicaSet(t *testing.T) (*Sleep, func()) {
+	url := os.Getenv("SLEEP_TEST_REPLSET")
+	if url == "" {
+		t.Skip("SLEEP_TEST_REPLSET is not set")
+	}
+	session, err := mgo.DialWithTimeout(url, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "sleep_test_watch"
+	session.DB(name).DropDatabase()
+	return New(session, name), func() {
+		session.DB(name).DropDatabase()
+		session.Close()
+	}
+}
+
+// nextEvent reads the next event of a stream, failing the test if none arrives in time
+func nextEvent(t *testing.T, stream *ChangeStream) ChangeEvent {
+	events := make(chan ChangeEvent, 1)
+	go func() {
+		event := ChangeEvent{}
+		if stream.Next(&event) {
+			events <- event
+		}
+		close(events)
+	}()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("stream stopped: %v", stream.Err())
+		}
+		return event
+	case <-time.After(20 * time.Second):
+		t.Fatal("no event arrived")
+	}
+	return ChangeEvent{}
+}
+
+func TestWatchTailsChanges(t *testing.T) {
+	z, done := testReplicaSet(t)
+	defer done()
+	People := z.Register(testPerson{}, "people")
+	//the collection must exist before it can be watched on older servers
+	if err := z.Db.C("people").Create(&mgo.CollectionInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	store := z.TokenCollection("resume_tokens")
+
+	stream, err := People.Watch(nil, WatchOptions{FullDocument: "updateLookup", TokenStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	person := &testPerson{Name: "joe"}
+	People.CreateDoc(person)
+	if err := person.Save(); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, stream)
+	if event.Id != person.Id || event.Document.(*testPerson).Name != "joe" {
+		t.Fatalf("got event %+v for the save", event)
+	}
+
+	//the stream keeps tailing after the first batch
+	if err := People.UpdateId(person.Id, bson.M{"$set": bson.M{"name": "jane"}}); err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, stream)
+	if event.Operation != "update" || event.UpdatedFields["name"] != "jane" {
+		t.Fatalf("got event %+v for the update", event)
+	}
+	stream.Close()
+
+	//a new stream resumes after the last handled event
+	if err := People.RemoveId(person.Id); err != nil {
+		t.Fatal(err)
+	}
+	stream, err = People.Watch(nil, WatchOptions{TokenStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	event = nextEvent(t, stream)
+	if event.Operation != "delete" || event.Id != person.Id {
+		t.Fatalf("got event %+v after resuming", event)
+	}
+}